@@ -0,0 +1,264 @@
+package canary
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/canaryio/canary/pkg/maintenance"
+	"github.com/canaryio/canary/pkg/manifest"
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+// AdminSource is the source attribute of targets added through the admin API.
+const AdminSource = "admin"
+
+// adminTarget describes a target, and the state of its sensor, in the
+// responses of the admin API.
+type adminTarget struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Interval   int      `json:"interval"`
+	Tags       []string `json:"tags"`
+	AdHoc      bool     `json:"adhoc"`
+	IsOK       bool     `json:"isOK"`
+	StateCount int      `json:"stateCount"`
+	Flapping   bool     `json:"flapping"`
+	Paused     bool     `json:"paused"`
+}
+
+// AdminHandler returns the handler of the admin HTTP API, which manages the
+// running targets:
+//
+//	GET    /targets               lists the targets and the state of their sensors
+//	POST   /targets               adds an ad-hoc target
+//	DELETE /targets/{name}        removes an ad-hoc target
+//	POST   /targets/{name}/pause  stops a sensor from sampling
+//	POST   /targets/{name}/resume resumes a paused sensor
+//	POST   /reload                reloads the manifest
+//	GET    /silences              lists the silences that have not ended
+//	POST   /silences              adds a silence
+//	DELETE /silences/{id}         removes a silence
+//
+// The read-only status pages of StatusHandler are served too.
+//
+// Ad-hoc targets and silences are kept across reloads, but are lost when
+// canaryd exits.
+// Like reloads, every change to the targets is made by the reloader.
+func (c *Canary) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/targets", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			c.listTargets(w, r)
+		case "POST":
+			c.addTarget(w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	})
+	mux.HandleFunc("/targets/", func(w http.ResponseWriter, r *http.Request) {
+		name, action := strings.TrimPrefix(r.URL.Path, "/targets/"), ""
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name, action = name[:i], name[i+1:]
+		}
+
+		switch {
+		case action == "" && r.Method == "DELETE":
+			c.removeTarget(w, name)
+		case action == "pause" && r.Method == "POST":
+			c.pauseTarget(w, name, true)
+		case action == "resume" && r.Method == "POST":
+			c.pauseTarget(w, name, false)
+		case action == "":
+			methodNotAllowed(w, "DELETE")
+		case action == "pause" || action == "resume":
+			methodNotAllowed(w, "POST")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	mux.HandleFunc("/silences", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			writeJSON(w, http.StatusOK, c.maintenance.Silences())
+		case "POST":
+			c.addSilence(w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	})
+	mux.HandleFunc("/silences/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			methodNotAllowed(w, "DELETE")
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/silences/")
+		if !c.maintenance.RemoveSilence(id) {
+			http.Error(w, fmt.Sprintf("no silence with id %q", id), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	status := c.StatusHandler()
+	mux.Handle("/status", status)
+	mux.Handle("/status.html", status)
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		go c.reload()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return mux
+}
+
+func (c *Canary) listTargets(w http.ResponseWriter, r *http.Request) {
+	adhoc := make(map[string]bool)
+	for _, t := range c.adhocTargets() {
+		adhoc[t.Name] = true
+	}
+
+	targets := []adminTarget{}
+	for _, s := range c.sensors() {
+		state := s.State()
+		targets = append(targets, adminTarget{
+			Name:       s.Target.Name,
+			URL:        s.Target.URL.String(),
+			Interval:   s.Target.Interval,
+			Tags:       s.Target.Tags,
+			AdHoc:      adhoc[s.Target.Name],
+			IsOK:       state.IsOK,
+			StateCount: state.StateCount,
+			Flapping:   state.Flapping,
+			Paused:     state.Paused,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, targets)
+}
+
+func (c *Canary) addTarget(w http.ResponseWriter, r *http.Request) {
+	var target sampler.Target
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		http.Error(w, fmt.Sprintf("invalid target: %s", err), http.StatusBadRequest)
+		return
+	}
+	if target.Interval == 0 {
+		target.Interval = c.Config.DefaultSampleInterval
+	}
+	attributes := map[string]string{manifest.SourceAttribute: AdminSource}
+	for k, v := range target.Attributes {
+		attributes[k] = v
+	}
+	target.Attributes = attributes
+	target.SetHash()
+
+	err := c.setAdhoc(func(adhoc []sampler.Target) ([]sampler.Target, error) {
+		return append(adhoc, target), nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (c *Canary) removeTarget(w http.ResponseWriter, name string) {
+	err := c.setAdhoc(func(adhoc []sampler.Target) ([]sampler.Target, error) {
+		for i, t := range adhoc {
+			if t.Name == name {
+				return append(adhoc[:i], adhoc[i+1:]...), nil
+			}
+		}
+		return nil, errNotFound
+	})
+	if err == errNotFound {
+		http.Error(w, fmt.Sprintf("no ad-hoc target named %q", name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// silenceRequest is the body of a request to add a silence.  It ends at End,
+// or Duration seconds after it starts.
+type silenceRequest struct {
+	Targets  []string   `json:"targets"`
+	Tags     []string   `json:"tags"`
+	Start    *time.Time `json:"start"`
+	End      *time.Time `json:"end"`
+	Duration int        `json:"duration"`
+	Comment  string     `json:"comment"`
+}
+
+func (c *Canary) addSilence(w http.ResponseWriter, r *http.Request) {
+	var req silenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid silence: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	s := maintenance.Silence{Targets: req.Targets, Tags: req.Tags, Comment: req.Comment}
+	if req.Start != nil {
+		s.Start = *req.Start
+	} else {
+		s.Start = time.Now()
+	}
+	switch {
+	case req.End != nil && req.Duration == 0:
+		s.End = *req.End
+	case req.End == nil && req.Duration > 0:
+		s.End = s.Start.Add(time.Duration(req.Duration) * time.Second)
+	default:
+		http.Error(w, "invalid silence: one of end and a positive duration must be set", http.StatusBadRequest)
+		return
+	}
+
+	s, err := c.maintenance.AddSilence(s)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid silence: %s", err), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s)
+}
+
+// pauseTarget pauses, or resumes, the sensor of a target.
+func (c *Canary) pauseTarget(w http.ResponseWriter, name string, pause bool) {
+	for _, s := range c.sensors() {
+		if s.Target.Name != name {
+			continue
+		}
+		if pause {
+			s.Pause()
+		} else {
+			s.Resume()
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	http.Error(w, fmt.Sprintf("no target named %q", name), http.StatusNotFound)
+}
+
+var errNotFound = errors.New("not found")
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
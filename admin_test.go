@@ -0,0 +1,141 @@
+package canary
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(t *testing.T, method, url, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// waitForTargets polls the admin API until check accepts the listed targets.
+func waitForTargets(t *testing.T, url string, check func([]adminTarget) bool) []adminTarget {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := adminRequest(t, "GET", url+"/targets", "")
+		var targets []adminTarget
+		err := json.NewDecoder(resp.Body).Decode(&targets)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if check(targets) {
+			return targets
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for targets, got %+v", targets)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAdminAdHocTargets(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer site.Close()
+
+	c := New(nil)
+	c.Config.DefaultSampleInterval = 1
+	c.Run()
+
+	admin := httptest.NewServer(c.AdminHandler())
+	defer admin.Close()
+
+	target := fmt.Sprintf(`{"name": "site", "url": "%s", "tags": ["adhoc"]}`, site.URL)
+	if resp := adminRequest(t, "POST", admin.URL+"/targets", target); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the target to be added, got HTTP status %d", resp.StatusCode)
+	}
+
+	targets := waitForTargets(t, admin.URL, func(targets []adminTarget) bool {
+		return len(targets) == 1 && targets[0].StateCount > 0
+	})
+	if site := targets[0]; site.Name != "site" || !site.AdHoc || !site.IsOK || site.Interval != 1 {
+		t.Fatalf("expected an ad-hoc target that is up, got %+v", site)
+	}
+
+	if resp := adminRequest(t, "POST", admin.URL+"/targets", target); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a duplicate target to be rejected, got HTTP status %d", resp.StatusCode)
+	}
+
+	if resp := adminRequest(t, "POST", admin.URL+"/targets/site/pause", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the target to be paused, got HTTP status %d", resp.StatusCode)
+	}
+	waitForTargets(t, admin.URL, func(targets []adminTarget) bool {
+		return len(targets) == 1 && targets[0].Paused
+	})
+	if resp := adminRequest(t, "POST", admin.URL+"/targets/missing/pause", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected an unknown target not to be found, got HTTP status %d", resp.StatusCode)
+	}
+
+	if resp := adminRequest(t, "DELETE", admin.URL+"/targets/site", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the target to be removed, got HTTP status %d", resp.StatusCode)
+	}
+	waitForTargets(t, admin.URL, func(targets []adminTarget) bool {
+		return len(targets) == 0
+	})
+	if resp := adminRequest(t, "DELETE", admin.URL+"/targets/site", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a removed target not to be found, got HTTP status %d", resp.StatusCode)
+	}
+}
+
+func TestAdminSilences(t *testing.T) {
+	c := New(nil)
+	admin := httptest.NewServer(c.AdminHandler())
+	defer admin.Close()
+
+	resp := adminRequest(t, "POST", admin.URL+"/silences", `{"tags": ["api"], "duration": 3600, "comment": "deploying"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the silence to be added, got HTTP status %d", resp.StatusCode)
+	}
+	var added struct {
+		ID      string    `json:"id"`
+		Start   time.Time `json:"start"`
+		End     time.Time `json:"end"`
+		Comment string    `json:"comment"`
+	}
+	json.NewDecoder(resp.Body).Decode(&added)
+	resp.Body.Close()
+	if added.ID == "" || added.End.Sub(added.Start) != time.Hour || added.Comment != "deploying" {
+		t.Fatalf("expected an hour long silence with an ID, got %+v", added)
+	}
+
+	for _, body := range []string{
+		`{"tags": ["api"]}`,
+		`{"tags": ["api"], "duration": 60, "end": "2030-01-01T00:00:00Z"}`,
+		`{"tags": ["api"], "end": "2000-01-01T00:00:00Z"}`,
+		`not json`,
+	} {
+		if resp := adminRequest(t, "POST", admin.URL+"/silences", body); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got HTTP status %d", body, resp.StatusCode)
+		}
+	}
+
+	resp = adminRequest(t, "GET", admin.URL+"/silences", "")
+	var silences []struct{ ID string }
+	json.NewDecoder(resp.Body).Decode(&silences)
+	resp.Body.Close()
+	if len(silences) != 1 || silences[0].ID != added.ID {
+		t.Fatalf("expected the silence to be listed, got %+v", silences)
+	}
+
+	if resp := adminRequest(t, "DELETE", admin.URL+"/silences/"+added.ID, ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the silence to be removed, got HTTP status %d", resp.StatusCode)
+	}
+	if resp := adminRequest(t, "DELETE", admin.URL+"/silences/"+added.ID, ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a removed silence not to be found, got HTTP status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,23 @@
+package canary
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(time.Second, 5*time.Second)
+
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	}
+	for i, e := range expected {
+		if d := b.Next(); d != e {
+			t.Fatalf("expected delay %d to be %s, got %s", i, e, d)
+		}
+	}
+}
@@ -1,38 +1,320 @@
 package canary
 
 import (
+	"crypto/md5"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/canaryio/canary/pkg/filewatch"
+	"github.com/canaryio/canary/pkg/maintenance"
 	"github.com/canaryio/canary/pkg/manifest"
+	"github.com/canaryio/canary/pkg/sampler"
 	"github.com/canaryio/canary/pkg/sensor"
 )
 
+const (
+	minReloadBackoff = time.Second
+	maxReloadBackoff = 5 * time.Minute
+
+	// how long a watched manifest must be left alone before it is reloaded
+	manifestWatchDebounce = 500 * time.Millisecond
+)
+
+// reloadVars exposes the outcome of manifest reloads via expvar.
+var reloadVars = expvar.NewMap("manifest_reload")
+
+// ReloadStatus describes the outcome of manifest reload attempts.
+type ReloadStatus struct {
+	LastAttempt time.Time
+	LastSuccess time.Time
+	LastError   error
+	Failures    int // consecutive failed attempts
+}
+
 type Canary struct {
 	Config     Config
+	Loader     *manifest.Loader
 	Manifest   manifest.Manifest
 	Publishers []Publisher
-	Sensors    []sensor.Sensor
+	Sensors    []*sensor.Sensor
 	OutputChan chan sensor.Measurement
 	ReloadChan chan manifest.Manifest
+
+	// removals carries the targets of stopped sensors to the goroutine
+	// publishing measurements, see TargetRemover.
+	removals chan removal
+
+	reloading    int32
+	reloadQueue  chan struct{} // holds a reload requested while one runs
+	reloadMu     sync.Mutex
+	reloadStatus ReloadStatus
+
+	sensorsMu sync.Mutex // guards Sensors
+
+	// maintenance holds the windows of the running manifest, and the
+	// silences added through the admin API.
+	maintenance maintenance.Calendar
+
+	// targetsMu guards the last loaded manifest, the ad-hoc targets added
+	// through the admin API, and the hash of the last manifest handed to
+	// the reloader.  It is held while handing a manifest over, so that the
+	// last manifest composed is the one the reloader runs; handing over
+	// never blocks, see apply.
+	targetsMu sync.Mutex
+	loaded    manifest.Manifest
+	adhoc     []sampler.Target
+	applied   string
 }
 
 // New returns a pointer to a new Publsher.
 func New(publishers []Publisher) *Canary {
 	return &Canary{
-		Publishers: publishers,
-		OutputChan: make(chan sensor.Measurement),
+		Publishers:  publishers,
+		OutputChan:  make(chan sensor.Measurement),
+		ReloadChan:  make(chan manifest.Manifest, 1),
+		reloadQueue: make(chan struct{}, 1),
+		removals:    make(chan removal),
 	}
 }
 
+// ReloadStatus returns the outcome of the most recent manifest reload attempts.
+func (c *Canary) ReloadStatus() ReloadStatus {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+	return c.reloadStatus
+}
+
+func (c *Canary) recordReload(err error) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	now := time.Now()
+	c.reloadStatus.LastAttempt = now
+	c.reloadStatus.LastError = err
+	if err == nil {
+		c.reloadStatus.LastSuccess = now
+		c.reloadStatus.Failures = 0
+	} else {
+		c.reloadStatus.Failures++
+	}
+
+	attempt := new(expvar.Int)
+	attempt.Set(now.Unix())
+	reloadVars.Set("last_attempt", attempt)
+	failures := new(expvar.Int)
+	failures.Set(int64(c.reloadStatus.Failures))
+	reloadVars.Set("failures", failures)
+	lastError := new(expvar.String)
+	if err == nil {
+		success := new(expvar.Int)
+		success.Set(now.Unix())
+		reloadVars.Set("last_success", success)
+	} else {
+		lastError.Set(err.Error())
+		reloadVars.Add("total_failures", 1)
+	}
+	reloadVars.Set("last_error", lastError)
+}
+
+// reload loads the manifest and hands it to the reloader if it has changed.
+// If the manifest cannot be fetched, parsed or validated, the running manifest
+// and sensors are kept and the load is retried with backoff until it succeeds.
+// Only one reload runs at a time.  A reload requested while another runs is
+// queued, and the manifest is loaded again once the running reload is done,
+// or at once if it is waiting to retry, so that no change is missed.
+func (c *Canary) reload() {
+	if c.Loader == nil {
+		log.Printf("no manifest loader configured, ignoring reload")
+		return
+	}
+
+	select {
+	case c.reloadQueue <- struct{}{}:
+	default:
+		// a reload is already queued, and will see this change too
+	}
+
+	if !atomic.CompareAndSwapInt32(&c.reloading, 0, 1) {
+		log.Printf("manifest reload already in progress, reloading again once it is done")
+		return
+	}
+	for {
+		c.runQueuedReloads()
+		atomic.StoreInt32(&c.reloading, 0)
+
+		// a reload queued after the queue was found empty, but before
+		// the flag was cleared, is left to this goroutine
+		if len(c.reloadQueue) == 0 || !atomic.CompareAndSwapInt32(&c.reloading, 0, 1) {
+			return
+		}
+	}
+}
+
+// runQueuedReloads loads the manifest until no reload is queued.
+func (c *Canary) runQueuedReloads() {
+	for {
+		select {
+		case <-c.reloadQueue:
+		default:
+			return
+		}
+
+		b := newBackoff(minReloadBackoff, maxReloadBackoff)
+		for {
+			m, err := c.Loader.Load()
+			if err == nil {
+				err = c.setLoaded(m)
+			}
+			c.recordReload(err)
+			if err == nil {
+				break
+			}
+
+			d := b.Next()
+			log.Printf("manifest reload failed, keeping current manifest, retrying in %s: %s", d, err)
+			select {
+			case <-time.After(d):
+			case <-c.reloadQueue:
+				log.Printf("manifest reload requested, retrying now")
+			}
+		}
+	}
+}
+
+// setLoaded replaces the loaded manifest, handing it to the reloader along
+// with the ad-hoc targets.  The manifest takes precedence over ad-hoc
+// targets: those that share a name with one of its targets are removed.
+func (c *Canary) setLoaded(m manifest.Manifest) error {
+	c.targetsMu.Lock()
+	defer c.targetsMu.Unlock()
+
+	names := make(map[string]bool)
+	for _, t := range m.Targets {
+		names[t.Name] = true
+	}
+	var adhoc []sampler.Target
+	for _, t := range c.adhoc {
+		if names[t.Name] {
+			log.Printf("removing ad-hoc target %s, now defined by the manifest", t.Name)
+			continue
+		}
+		adhoc = append(adhoc, t)
+	}
+
+	composed, err := compose(m, adhoc)
+	if err != nil {
+		return err
+	}
+	c.loaded = m
+	c.adhoc = adhoc
+	c.apply(composed)
+	return nil
+}
+
+// setAdhoc replaces the ad-hoc targets with the result of change, handing
+// them to the reloader along with the loaded manifest.
+func (c *Canary) setAdhoc(change func(adhoc []sampler.Target) ([]sampler.Target, error)) error {
+	c.targetsMu.Lock()
+	defer c.targetsMu.Unlock()
+
+	adhoc, err := change(append([]sampler.Target(nil), c.adhoc...))
+	if err != nil {
+		return err
+	}
+	composed, err := compose(c.loaded, adhoc)
+	if err != nil {
+		return err
+	}
+	c.adhoc = adhoc
+	c.apply(composed)
+	return nil
+}
+
+// apply hands m to the reloader if it differs from the last manifest handed
+// over.  ReloadChan holds a single manifest: one the reloader has not taken
+// yet is replaced, so that apply does not wait for the reloader, which may
+// be busy stopping sensors.  targetsMu must be held.
+func (c *Canary) apply(m manifest.Manifest) {
+	if m.Hash == c.applied {
+		return
+	}
+	select {
+	case <-c.ReloadChan:
+	default:
+	}
+	c.ReloadChan <- m
+	c.applied = m.Hash
+}
+
+// compose returns the manifest m with the ad-hoc targets appended.  The
+// result is validated, so that ad-hoc targets cannot clash with those of
+// the manifest.
+func compose(m manifest.Manifest, adhoc []sampler.Target) (manifest.Manifest, error) {
+	if len(adhoc) == 0 {
+		return m, nil
+	}
+
+	composed := m
+	composed.Targets = append(append([]sampler.Target(nil), m.Targets...), adhoc...)
+	composed.StartDelays = append(append([]float64(nil), m.StartDelays...), make([]float64, len(adhoc))...)
+
+	hasher := md5.New()
+	io.WriteString(hasher, m.Hash)
+	for _, t := range adhoc {
+		io.WriteString(hasher, t.Hash)
+	}
+	composed.Hash = hex.EncodeToString(hasher.Sum(nil))
+
+	return composed, composed.Validate()
+}
+
+// adhocTargets returns the ad-hoc targets added through the admin API.
+func (c *Canary) adhocTargets() []sampler.Target {
+	c.targetsMu.Lock()
+	defer c.targetsMu.Unlock()
+	return append([]sampler.Target(nil), c.adhoc...)
+}
+
+// sensors returns the running sensors.
+func (c *Canary) sensors() []*sensor.Sensor {
+	c.sensorsMu.Lock()
+	defer c.sensorsMu.Unlock()
+	return append([]*sensor.Sensor(nil), c.Sensors...)
+}
+
 func (c *Canary) publishMeasurements() {
-	// publish each incoming measurement
-	for m := range c.OutputChan {
-		for _, p := range c.Publishers {
-			p.Publish(m)
+	for {
+		select {
+		case m, ok := <-c.OutputChan:
+			if !ok {
+				return
+			}
+			// publish each incoming measurement
+			m.InMaintenance = c.maintenance.InMaintenance(m.Target, time.Now())
+			for _, p := range c.Publishers {
+				p.Publish(m)
+			}
+		case r := <-c.removals:
+			// a sensor's measurements are received before it stops, so
+			// none of them can follow the removal of its target
+			for _, p := range c.Publishers {
+				if rp, ok := p.(TargetReplacer); ok && r.changed != nil {
+					rp.ReplaceTarget(r.target, *r.changed)
+				} else if rm, ok := p.(TargetRemover); ok {
+					rm.RemoveTarget(r.target)
+				}
+			}
 		}
 	}
 }
@@ -44,28 +326,30 @@ func (c *Canary) SignalHandler() {
 	for s := range signalChan {
 		switch s {
 		case syscall.SIGINT:
-			for _, sensor := range c.Sensors {
+			for _, sensor := range c.sensors() {
 				sensor.Stop()
 			}
 			os.Exit(0)
 		case syscall.SIGHUP:
-			manifest, err := manifest.Get(c.Config.ManifestURL, c.Config.DefaultSampleInterval)
-			if err != nil {
-				log.Fatal(err)
-			}
-			// Split reload logic into reloader() as to allow other things to trigger a manifest reload
-			c.ReloadChan <- manifest
+			go c.reload()
 		}
 	}
 }
 
+// removal is the target of a sensor stopped by a reload, and the target of
+// the same name that replaces it, if any.
+type removal struct {
+	target  sampler.Target
+	changed *sampler.Target
+}
+
 func (c *Canary) reloader() {
 	if c.ReloadChan == nil {
-		c.ReloadChan = make(chan manifest.Manifest)
+		c.ReloadChan = make(chan manifest.Manifest, 1)
 	}
 
 	for m := range c.ReloadChan {
-		stoppingSensors := []sensor.Sensor{}
+		stoppingSensors := []*sensor.Sensor{}
 		for _, sensor := range c.Sensors {
 			found := false
 			for _, newTarget := range m.Targets {
@@ -81,9 +365,17 @@ func (c *Canary) reloader() {
 		}
 		for _, sensor := range stoppingSensors {
 			<-sensor.StopNotifyChan
+			r := removal{target: sensor.Target}
+			for i, t := range m.Targets {
+				if t.Name == sensor.Target.Name {
+					r.changed = &m.Targets[i]
+				}
+			}
+			c.removals <- r
 		}
 
 		c.Manifest = m
+		c.setWindows()
 		if c.Config.RampupSensors {
 			c.Manifest.GenerateRampupDelays(c.Config.DefaultSampleInterval)
 		}
@@ -92,9 +384,17 @@ func (c *Canary) reloader() {
 	}
 }
 
+// setWindows schedules the maintenance windows of the running manifest.
+func (c *Canary) setWindows() {
+	// manifests are validated, so this only fails for manifests built by hand
+	if err := c.maintenance.SetWindows(c.Manifest.Maintenance); err != nil {
+		log.Printf("ignoring maintenance windows: %s", err)
+	}
+}
+
 func (c *Canary) startSensors() {
-	oldSensors := c.Sensors
-	c.Sensors = []sensor.Sensor{} // reset the slice
+	oldSensors := c.sensors()
+	sensors := []*sensor.Sensor{}
 
 	// spinup a sensor for each target
 	for index, target := range c.Manifest.Targets {
@@ -107,7 +407,7 @@ func (c *Canary) startSensors() {
 		if found {
 			for _, oldSensor := range oldSensors {
 				if oldSensor.Target.Hash == target.Hash {
-					c.Sensors = append(c.Sensors, oldSensor)
+					sensors = append(sensors, oldSensor)
 				}
 			}
 		} else {
@@ -116,7 +416,7 @@ func (c *Canary) startSensors() {
 				timeout = c.Config.MaxSampleTimeout
 			}
 
-			sensor := sensor.Sensor{
+			sensor := &sensor.Sensor{
 				Target:         target,
 				C:              c.OutputChan,
 				StopChan:       make(chan int, 1),
@@ -125,32 +425,94 @@ func (c *Canary) startSensors() {
 				IsOK:           false,
 				Timeout:        timeout,
 			}
-			c.Sensors = append(c.Sensors, sensor)
+			// a target whose settings changed keeps its state
+			for _, oldSensor := range oldSensors {
+				if oldSensor.Target.Name == target.Name {
+					sensor.Inherit(oldSensor)
+				}
+			}
+			sensors = append(sensors, sensor)
 
 			go sensor.Start(c.Manifest.StartDelays[index])
 		}
 	}
+
+	c.sensorsMu.Lock()
+	c.Sensors = sensors
+	c.sensorsMu.Unlock()
 }
 
 func (c *Canary) StartAutoReload(interval time.Duration) {
 	t := time.NewTicker(interval)
 	for {
 		<-t.C
-		manifest, err := manifest.Get(c.Config.ManifestURL, c.Config.DefaultSampleInterval)
-		if err != nil {
-			log.Fatal(err)
+		c.reload()
+	}
+}
+
+// WatchManifest reloads the manifest whenever one of its file:// sources is
+// written or replaced, rather than waiting for SIGHUP or the auto reload
+// interval.  Directory and glob sources are watched for any matching file.
+func (c *Canary) WatchManifest() error {
+	watching := false
+	for _, source := range c.Config.ManifestURLs {
+		if !strings.HasPrefix(source, "file://") {
+			continue
 		}
-		if manifest.Hash != c.Manifest.Hash {
-			c.ReloadChan <- manifest
+
+		w, err := watchSource(source[7:])
+		if err != nil {
+			return err
 		}
+		watching = true
+
+		go func() {
+			for range w.C {
+				c.reload()
+			}
+		}()
+	}
+
+	if !watching {
+		return fmt.Errorf("only file:// manifests can be watched, got %s", strings.Join(c.Config.ManifestURLs, ","))
+	}
+	return nil
+}
+
+// watchSource watches the file, directory or glob pattern at path.
+func watchSource(path string) (*filewatch.Watcher, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return filewatch.NewGlob(filepath.Join(path, "*.json"), manifestWatchDebounce)
+	}
+	if strings.ContainsAny(path, `*?[`) {
+		return filewatch.NewGlob(path, manifestWatchDebounce)
+	}
+	return filewatch.New(path, manifestWatchDebounce)
+}
+
+// watchLoader reloads the manifest whenever the loader reports that a
+// watched discovery source has changed.
+func (c *Canary) watchLoader() {
+	for range c.Loader.Changes() {
+		c.reload()
 	}
 }
 
 func (c *Canary) Run() {
+	c.targetsMu.Lock()
+	c.loaded = c.Manifest
+	c.applied = c.Manifest.Hash
+	c.targetsMu.Unlock()
+	c.setWindows()
+
 	// create and start sensors
 	c.startSensors()
 	// start a go routine for watching config reloads
 	go c.reloader()
+	// start a go routine for reloading when discovered targets change
+	if c.Loader != nil {
+		go c.watchLoader()
+	}
 	// start a go routine for measurement publishing.
 	go c.publishMeasurements()
 }
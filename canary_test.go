@@ -0,0 +1,222 @@
+package canary
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/maintenance"
+	"github.com/canaryio/canary/pkg/manifest"
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+// removingPublisher holds its first measurement until released.
+type removingPublisher struct {
+	publishing chan bool
+	release    chan bool
+	removed    chan sampler.Target
+	once       sync.Once
+}
+
+func (p *removingPublisher) Publish(sensor.Measurement) error {
+	p.once.Do(func() {
+		p.publishing <- true
+		<-p.release
+	})
+	return nil
+}
+
+func (p *removingPublisher) RemoveTarget(t sampler.Target) {
+	p.removed <- t
+}
+
+func TestReloaderRemovesTargetsFromPublishers(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer site.Close()
+
+	u, _ := sampler.NewJsonURL(site.URL)
+	target := sampler.Target{URL: *u, Name: "site", Interval: 1}
+	target.SetHash()
+
+	p := &removingPublisher{
+		publishing: make(chan bool),
+		release:    make(chan bool),
+		removed:    make(chan sampler.Target, 1),
+	}
+	c := New([]Publisher{p})
+	c.Manifest = manifest.Manifest{Targets: []sampler.Target{target}, StartDelays: []float64{0}}
+	c.Run()
+	<-p.publishing
+
+	c.ReloadChan <- manifest.Manifest{Hash: "empty"}
+
+	// the sensor stops at its next tick, but the target is only removed
+	// once its last measurement is published
+	select {
+	case <-p.removed:
+		t.Fatal("expected the target to be removed after its last measurement is published")
+	case <-time.After(1500 * time.Millisecond):
+	}
+	close(p.release)
+
+	select {
+	case removed := <-p.removed:
+		if removed.Hash != target.Hash {
+			t.Fatalf("expected %s to be removed, got %s", target.Name, removed.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the target to be removed from the publisher")
+	}
+}
+
+type channelPublisher chan sensor.Measurement
+
+func (p channelPublisher) Publish(m sensor.Measurement) error {
+	p <- m
+	return nil
+}
+
+func TestMaintenanceMarksMeasurements(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer site.Close()
+
+	u, _ := sampler.NewJsonURL(site.URL)
+	target := sampler.Target{URL: *u, Name: "site", Interval: 1, Tags: []string{"web"}}
+	target.SetHash()
+
+	start, end := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	p := make(channelPublisher, 1)
+	c := New([]Publisher{p})
+	c.Manifest = manifest.Manifest{
+		Targets:     []sampler.Target{target},
+		Maintenance: []maintenance.Window{{Tags: []string{"web"}, Start: &start, End: &end}},
+		StartDelays: []float64{0},
+	}
+	c.Run()
+
+	select {
+	case m := <-p:
+		if !m.InMaintenance {
+			t.Fatal("expected the measurement to be marked in maintenance")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a measurement")
+	}
+}
+
+func TestReloadRequestedDuringReload(t *testing.T) {
+	var mu sync.Mutex
+	name, requests := "first", 0
+	fetching, release := make(chan bool), make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n := name
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if first {
+			fetching <- true
+			<-release
+		}
+		fmt.Fprintf(w, `{"targets": [{"url": "http://www.canary.io", "name": %q}]}`, n)
+	}))
+	defer ts.Close()
+
+	c := New(nil)
+	c.Loader = manifest.NewLoader([]string{ts.URL}, 42)
+	go c.reload()
+	<-fetching
+
+	// the manifest changes while the first reload is reading it
+	mu.Lock()
+	name = "second"
+	mu.Unlock()
+	c.reload()
+	close(release)
+
+	// the first manifest may be replaced by the second before it is taken
+	for {
+		select {
+		case m := <-c.ReloadChan:
+			if len(m.Targets) == 1 && m.Targets[0].Name == "second" {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the second manifest to be applied")
+		}
+	}
+}
+
+func TestManifestTakesPrecedenceOverAdHocTargets(t *testing.T) {
+	u, _ := sampler.NewJsonURL("http://www.canary.io")
+	adhoc := sampler.Target{URL: *u, Name: "site", Interval: 1}
+	adhoc.SetHash()
+
+	// no reloader runs: handing manifests over must not block
+	c := New(nil)
+	err := c.setAdhoc(func([]sampler.Target) ([]sampler.Target, error) {
+		return []sampler.Target{adhoc}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := adhoc
+	target.Interval = 5
+	target.SetHash()
+	if err := c.setLoaded(manifest.Manifest{Targets: []sampler.Target{target}, StartDelays: []float64{0}, Hash: "manifest"}); err != nil {
+		t.Fatalf("expected the manifest to be loaded, got %s", err)
+	}
+
+	if adhoc := c.adhocTargets(); len(adhoc) != 0 {
+		t.Errorf("expected the clashing ad-hoc target to be removed, got %+v", adhoc)
+	}
+	if m := <-c.ReloadChan; len(m.Targets) != 1 || m.Targets[0].Interval != 5 {
+		t.Errorf("expected the manifest's target to be applied, got %+v", m.Targets)
+	}
+}
+
+func TestChangedTargetKeepsItsState(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer site.Close()
+
+	u, _ := sampler.NewJsonURL(site.URL)
+	target := sampler.Target{URL: *u, Name: "site", Interval: 1, ConfirmFailures: 2}
+	target.SetHash()
+
+	p := make(channelPublisher)
+	c := New([]Publisher{p})
+	c.Manifest = manifest.Manifest{Targets: []sampler.Target{target}, StartDelays: []float64{0}}
+	c.Run()
+
+	next := func() sensor.Measurement {
+		select {
+		case m := <-p:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a measurement")
+		}
+		return sensor.Measurement{}
+	}
+	for m := next(); m.IsOK; m = next() {
+	}
+
+	changed := target
+	changed.Tags = []string{"web"}
+	changed.SetHash()
+	c.ReloadChan <- manifest.Manifest{Hash: "changed", Targets: []sampler.Target{changed}, StartDelays: []float64{0}}
+
+	m := next()
+	for m.Target.Hash != changed.Hash {
+		m = next()
+	}
+	if m.IsOK || m.StateCount < 2 {
+		t.Fatalf("expected the changed target to stay down, got IsOK %t and StateCount %d", m.IsOK, m.StateCount)
+	}
+}
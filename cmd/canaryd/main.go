@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -11,17 +12,30 @@ import (
 
 	"github.com/canaryio/canary"
 
+	"github.com/canaryio/canary/pkg/alert"
+	"github.com/canaryio/canary/pkg/emailnotifier"
+	"github.com/canaryio/canary/pkg/graphitepublisher"
+	"github.com/canaryio/canary/pkg/influxpublisher"
 	"github.com/canaryio/canary/pkg/libratopublisher"
 	"github.com/canaryio/canary/pkg/manifest"
+	"github.com/canaryio/canary/pkg/opsgenienotifier"
+	"github.com/canaryio/canary/pkg/otlppublisher"
+	"github.com/canaryio/canary/pkg/pagerdutynotifier"
+	"github.com/canaryio/canary/pkg/prometheuspublisher"
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/slacknotifier"
+	"github.com/canaryio/canary/pkg/statsdpublisher"
 	"github.com/canaryio/canary/pkg/stdoutpublisher"
+	"github.com/canaryio/canary/pkg/webhookpublisher"
 )
 
 // builds the app configuration via ENV
 func getConfig() (c canary.Config, err error) {
-	c.ManifestURL = os.Getenv("MANIFEST_URL")
-	if c.ManifestURL == "" {
+	manifestURL := os.Getenv("MANIFEST_URL")
+	if manifestURL == "" {
 		err = fmt.Errorf("MANIFEST_URL not defined in ENV")
 	}
+	c.ManifestURLs = strings.Split(manifestURL, ",")
 
 	interval := os.Getenv("DEFAULT_SAMPLE_INTERVAL")
 	// if the variable is unset, an empty string will be returned
@@ -68,6 +82,9 @@ func getConfig() (c canary.Config, err error) {
 		c.RampupSensors = false
 	}
 
+	// Set WatchManifest if WATCH_MANIFEST is set to 'yes'
+	c.WatchManifest = os.Getenv("WATCH_MANIFEST") == "yes"
+
 	return
 }
 
@@ -81,13 +98,54 @@ func createPublishers() (publishers []canary.Publisher) {
 	for _, publisher := range publisherList {
 		switch publisher {
 		case "stdout":
-			publishers = append(publishers, stdoutpublisher.New())
+			p, err := stdoutpublisher.NewFromEnv()
+			if err != nil {
+				log.Fatal(err)
+			}
+			publishers = append(publishers, p)
 		case "librato":
 			p, err := libratopublisher.NewFromEnv()
 			if err != nil {
 				log.Fatal(err)
 			}
 			publishers = append(publishers, p)
+		case "prometheus":
+			p, err := prometheuspublisher.NewFromEnv()
+			if err != nil {
+				log.Fatal(err)
+			}
+			publishers = append(publishers, p)
+		case "statsd":
+			p, err := statsdpublisher.NewFromEnv()
+			if err != nil {
+				log.Fatal(err)
+			}
+			publishers = append(publishers, p)
+		case "graphite":
+			p, err := graphitepublisher.NewFromEnv()
+			if err != nil {
+				log.Fatal(err)
+			}
+			publishers = append(publishers, p)
+		case "influx":
+			p, err := influxpublisher.NewFromEnv()
+			if err != nil {
+				log.Fatal(err)
+			}
+			publishers = append(publishers, p)
+		case "otlp":
+			p, err := otlppublisher.NewFromEnv()
+			if err != nil {
+				log.Fatal(err)
+			}
+			publishers = append(publishers, p)
+			sampler.InjectTraceparent = os.Getenv("OTLP_TRACEPARENT") == "yes"
+		case "webhook":
+			p, err := webhookpublisher.NewFromEnv()
+			if err != nil {
+				log.Fatal(err)
+			}
+			publishers = append(publishers, p)
 		default:
 			log.Fatalf("Unknown publisher: %s", publisher)
 		}
@@ -96,13 +154,95 @@ func createPublishers() (publishers []canary.Publisher) {
 	return
 }
 
+// createAlertEngine loads the alert config at path, and creates the
+// notifier of each of its receivers.
+func createAlertEngine(path string) *alert.Engine {
+	config, err := alert.LoadConfig(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	notifiers := make(map[string]alert.Notifier)
+	for _, r := range config.Receivers {
+		var n alert.Notifier
+		switch r.Type {
+		case "log":
+			n = alert.LogNotifier{}
+		case "pagerduty":
+			n, err = pagerdutynotifier.NewFromSettings(r.Settings)
+		case "opsgenie":
+			n, err = opsgenienotifier.NewFromSettings(r.Settings)
+		case "slack":
+			n, err = slacknotifier.NewFromSettings(r.Settings)
+		case "email":
+			n, err = emailnotifier.NewFromSettings(r.Settings)
+		default:
+			log.Fatalf("Unknown type %q of receiver %s", r.Type, r.Name)
+		}
+		if err != nil {
+			log.Fatalf("receiver %s: %s", r.Name, err)
+		}
+		notifiers[r.Name] = n
+	}
+
+	engine, err := alert.New(config, notifiers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return engine
+}
+
+// validate loads and merges the manifests at urls and reports every problem
+// found, exiting non-zero if there are any.  Plain paths are treated as
+// file:// URLs.
+func validate(urls []string) {
+	for i, url := range urls {
+		if !strings.Contains(url, "://") {
+			urls[i] = "file://" + url
+		}
+	}
+
+	interval := 1
+	if s := os.Getenv("DEFAULT_SAMPLE_INTERVAL"); s != "" {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal("DEFAULT_SAMPLE_INTERVAL is not a valid integer")
+		}
+		interval = i
+	}
+
+	m, err := manifest.NewLoader(urls, interval).Load()
+	if verr, ok := err.(manifest.ValidationError); ok {
+		for _, fe := range verr {
+			fmt.Fprintln(os.Stderr, fe)
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s: ok, %d targets\n", strings.Join(urls, ","), len(m.Targets))
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "usage: %s validate <manifest>...\n", os.Args[0])
+			os.Exit(2)
+		}
+		validate(os.Args[2:])
+		return
+	}
+
 	conf, err := getConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	manifest, err := manifest.Get(conf.ManifestURL, conf.DefaultSampleInterval)
+	loader := manifest.NewLoader(conf.ManifestURLs, conf.DefaultSampleInterval)
+	manifest, err := loader.Load()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -111,16 +251,48 @@ func main() {
 		manifest.GenerateRampupDelays(conf.DefaultSampleInterval)
 	}
 
-	c := canary.New(createPublishers())
+	// expose runtime variables, such as the manifest reload status, via expvar
+	if addr := os.Getenv("DEBUG_ADDR"); addr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(addr, nil))
+		}()
+	}
+
+	publishers := createPublishers()
+	if path := os.Getenv("ALERT_CONFIG"); path != "" {
+		publishers = append(publishers, createAlertEngine(path))
+	}
+
+	c := canary.New(publishers)
 	c.Config = conf
+	c.Loader = loader
 	c.Manifest = manifest
 
 	// Start canary and block in the signal handler
 	c.Run()
 
+	// serve the read-only status pages
+	if addr := os.Getenv("STATUS_ADDR"); addr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(addr, c.StatusHandler()))
+		}()
+	}
+
+	// serve the admin API for managing targets at runtime
+	if addr := os.Getenv("ADMIN_ADDR"); addr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(addr, c.AdminHandler()))
+		}()
+	}
+
 	u, _ := time.ParseDuration("0s")
 	if c.Config.ReloadInterval != u {
 		go c.StartAutoReload(c.Config.ReloadInterval)
 	}
+	if c.Config.WatchManifest {
+		if err := c.WatchManifest(); err != nil {
+			log.Fatal(err)
+		}
+	}
 	c.SignalHandler()
 }
@@ -3,9 +3,10 @@ package canary
 import "time"
 
 type Config struct {
-	ManifestURL           string
+	ManifestURLs          []string
 	DefaultSampleInterval int
 	RampupSensors         bool
+	WatchManifest         bool
 	ReloadInterval        time.Duration
 	MaxSampleTimeout      int
 }
@@ -0,0 +1,72 @@
+// Package alert turns the stream of measurements into alerts: rules such as
+// "down for 3 consecutive samples" fire an alert for a target while they
+// hold, and resolve it once they no longer do.  Firing and resolved alerts
+// are routed by the target's tags to named receivers, which notify people.
+package alert
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+// Status is the stage of an alert's lifecycle.
+type Status string
+
+const (
+	Firing   Status = "firing"
+	Resolved Status = "resolved"
+)
+
+// Alert is a rule holding for a target.
+type Alert struct {
+	Rule        string
+	Status      Status
+	Target      sampler.Target
+	Summary     string // describes the condition, e.g. "www has been down for 3 consecutive samples"
+	StartsAt    time.Time
+	EndsAt      time.Time // zero while firing
+	Measurement sensor.Measurement
+
+	// Fingerprint identifies the alert across notifications: it is the same
+	// for every notification of a rule for a target of a given name, even if
+	// the target's settings change.
+	Fingerprint string
+}
+
+// Notifier is implemented by anything that can tell people about alerts.
+// Notify is called for each alert that fires, is repeated or is resolved.
+// Calls for a receiver are made one at a time, in order.
+type Notifier interface {
+	Notify(Alert) error
+}
+
+// LogNotifier logs alerts.
+type LogNotifier struct{}
+
+// Notify logs the alert.
+func (LogNotifier) Notify(a Alert) error {
+	log.Printf("alert: %s %s: %s", a.Status, a.Rule, a.Summary)
+	return nil
+}
+
+// fingerprint identifies the alerts of a rule for a target.
+func fingerprint(rule string, t sampler.Target) string {
+	sum := md5.Sum([]byte(rule + "\x00" + t.Name))
+	return hex.EncodeToString(sum[:])
+}
+
+// measuredAt returns when a measurement was taken.
+func measuredAt(m sensor.Measurement) time.Time {
+	switch {
+	case !m.Sample.TimeEnd.IsZero():
+		return m.Sample.TimeEnd
+	case !m.Sample.TimeStart.IsZero():
+		return m.Sample.TimeStart
+	}
+	return time.Now()
+}
@@ -0,0 +1,200 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Config configures the alert engine: the rules evaluated against every
+// measurement, and the routes that choose which receivers are notified.
+type Config struct {
+	Rules  []Rule  `json:"rules"`
+	Routes []Route `json:"routes"`
+
+	// DefaultReceivers are notified of alerts that match no route.
+	DefaultReceivers []string `json:"default_receivers"`
+
+	Receivers []ReceiverConfig `json:"receivers"`
+}
+
+// Rule is a condition that fires an alert for each target it holds for.
+// Exactly one of ConsecutiveDown, ErrorRate and LatencyP95 is set.
+type Rule struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"` // targets must have every tag; empty matches every target
+
+	// ConsecutiveDown fires once a target has been down for this many
+	// consecutive samples, and holds until the target is up.
+	ConsecutiveDown int `json:"consecutive_down"`
+
+	// ErrorRate fires once more than this percentage of the samples within
+	// Window failed, whether or not the target was confirmed down.
+	ErrorRate float64 `json:"error_rate"`
+
+	// LatencyP95 fires once the 95th percentile of the latency of the
+	// samples within Window is above it.
+	LatencyP95 Duration `json:"latency_p95"`
+
+	Window     Duration `json:"window"`      // for ErrorRate and LatencyP95
+	MinSamples int      `json:"min_samples"` // within Window before the rule is evaluated, defaults to 1
+
+	// ResolveAfter is how many consecutive samples the condition must not
+	// hold for before a firing alert is resolved, defaults to 1.
+	ResolveAfter int `json:"resolve_after"`
+
+	// RepeatInterval, if set, is how often a firing alert is notified again.
+	RepeatInterval Duration `json:"repeat_interval"`
+}
+
+// Route sends the alerts of targets with every one of Tags, and of one of
+// Rules if set, to Receivers.  Routes are matched in order; matching stops
+// at the first route that matches, unless it has Continue set.
+type Route struct {
+	Tags      []string `json:"tags"`
+	Rules     []string `json:"rules"`
+	Receivers []string `json:"receivers"`
+	Continue  bool     `json:"continue"`
+}
+
+// ReceiverConfig names a notifier.  Settings is the whole of the receiver's
+// JSON object, for the notifier of its Type to parse.
+type ReceiverConfig struct {
+	Name     string
+	Type     string
+	Settings json.RawMessage
+}
+
+// UnmarshalJSON keeps the receiver's JSON object as its Settings.
+func (r *ReceiverConfig) UnmarshalJSON(b []byte) error {
+	var common struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &common); err != nil {
+		return err
+	}
+	r.Name, r.Type = common.Name, common.Type
+	r.Settings = append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// Duration is a time.Duration written as a string such as "5m" in JSON.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations must be strings such as \"5m\"")
+	}
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// LoadConfig reads and validates the config file at path.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return c, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks the config for problems, returning every one found.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	receivers := make(map[string]bool)
+	for i, r := range c.Receivers {
+		switch {
+		case r.Name == "":
+			add("receivers[%d].name: must be set", i)
+		case receivers[r.Name]:
+			add("receivers[%d].name: %q is used by another receiver", i, r.Name)
+		}
+		if r.Type == "" {
+			add("receivers[%d].type: must be set", i)
+		}
+		receivers[r.Name] = true
+	}
+	checkReceivers := func(field string, names []string) {
+		for _, name := range names {
+			if !receivers[name] {
+				add("%s: unknown receiver %q", field, name)
+			}
+		}
+	}
+
+	rules := make(map[string]bool)
+	for i, r := range c.Rules {
+		switch {
+		case r.Name == "":
+			add("rules[%d].name: must be set", i)
+		case rules[r.Name]:
+			add("rules[%d].name: %q is used by another rule", i, r.Name)
+		}
+		rules[r.Name] = true
+
+		conditions := 0
+		if r.ConsecutiveDown > 0 {
+			conditions++
+		}
+		if r.ErrorRate > 0 {
+			conditions++
+			if r.ErrorRate >= 100 {
+				add("rules[%d].error_rate: must be below 100", i)
+			}
+		}
+		if r.LatencyP95.Duration > 0 {
+			conditions++
+		}
+		if conditions != 1 {
+			add("rules[%d]: exactly one of consecutive_down, error_rate and latency_p95 must be set", i)
+		}
+		if (r.ErrorRate > 0 || r.LatencyP95.Duration > 0) && r.Window.Duration <= 0 {
+			add("rules[%d].window: must be set for error_rate and latency_p95", i)
+		}
+		if r.MinSamples < 0 || r.ResolveAfter < 0 || r.RepeatInterval.Duration < 0 {
+			add("rules[%d]: min_samples, resolve_after and repeat_interval cannot be negative", i)
+		}
+	}
+
+	for i, r := range c.Routes {
+		for _, name := range r.Rules {
+			if !rules[name] {
+				add("routes[%d].rules: unknown rule %q", i, name)
+			}
+		}
+		if len(r.Receivers) == 0 {
+			add("routes[%d].receivers: must be set", i)
+		}
+		checkReceivers(fmt.Sprintf("routes[%d].receivers", i), r.Receivers)
+	}
+	checkReceivers("default_receivers", c.DefaultReceivers)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid alert config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package alert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "alerts.json")
+	ioutil.WriteFile(path, []byte(`{
+		"rules": [
+			{"name": "down", "consecutive_down": 3},
+			{"name": "slow", "latency_p95": "2s", "window": "10m", "tags": ["prod"]}
+		],
+		"routes": [{"tags": ["prod"], "receivers": ["oncall"]}],
+		"default_receivers": ["log"],
+		"receivers": [
+			{"name": "oncall", "type": "pagerduty", "routing_key": "secret"},
+			{"name": "log", "type": "log"}
+		]
+	}`), 0644)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Rules[1].LatencyP95.Duration != 2*time.Second || c.Rules[1].Window.Duration != 10*time.Minute {
+		t.Errorf("expected durations to be parsed, got %+v", c.Rules[1])
+	}
+	if r := c.Receivers[0]; r.Name != "oncall" || r.Type != "pagerduty" || !strings.Contains(string(r.Settings), `"routing_key": "secret"`) {
+		t.Errorf("expected the receiver's settings to be kept, got %+v", r)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Config{
+		Rules: []Rule{
+			{Name: "both", ConsecutiveDown: 1, ErrorRate: 10, Window: Duration{time.Minute}},
+			{Name: "rate", ErrorRate: 10},
+		},
+		Routes:           []Route{{Rules: []string{"missing"}, Receivers: []string{"nobody"}}},
+		DefaultReceivers: []string{"log"},
+		Receivers:        []ReceiverConfig{{Name: "log", Type: "log"}},
+	}
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected the config to be invalid")
+	}
+	for _, problem := range []string{
+		"rules[0]: exactly one of",
+		"rules[1].window: must be set",
+		`routes[0].rules: unknown rule "missing"`,
+		`routes[0].receivers: unknown receiver "nobody"`,
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported, got %s", problem, err)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package alert
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+// queueSize bounds the alerts waiting for each receiver.
+const queueSize = 1000
+
+// Engine evaluates rules against every measurement, and notifies the
+// receivers routed to whenever an alert fires or is resolved.  While an
+// alert is firing, it is not notified again unless its rule has a
+// RepeatInterval.
+//
+// Engine implements the canary.Publisher interface, so that it sees every
+// measurement.
+type Engine struct {
+	config    Config
+	receivers map[string]*receiver
+
+	mu     sync.Mutex
+	states map[stateKey]*ruleState
+}
+
+// stateKey identifies the state of a rule for a target.  Targets are
+// known by name, so that their alerts outlive changes to their settings.
+type stateKey struct {
+	rule   string
+	target string
+}
+
+// ruleState is the state of a rule for one target.
+type ruleState struct {
+	window   []observation // within the rule's window, oldest first
+	firing   bool
+	alert    Alert
+	clear    int       // consecutive samples the condition has not held for
+	notified time.Time // when the firing alert was last notified
+}
+
+type observation struct {
+	at      time.Time
+	ok      bool
+	latency time.Duration // zero if the sample did not complete
+}
+
+// receiver delivers alerts to a notifier, in order, in the background.
+type receiver struct {
+	name     string
+	notifier Notifier
+	queue    chan Alert
+	done     chan struct{}
+}
+
+// New returns a pointer to a new Engine.  notifiers holds the notifier of
+// every receiver named in the config.
+func New(config Config, notifiers map[string]Notifier) (*Engine, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	e := &Engine{
+		config:    config,
+		receivers: make(map[string]*receiver),
+		states:    make(map[stateKey]*ruleState),
+	}
+	for _, rc := range config.Receivers {
+		n, ok := notifiers[rc.Name]
+		if !ok {
+			return nil, fmt.Errorf("no notifier for receiver %q", rc.Name)
+		}
+		r := &receiver{
+			name:     rc.Name,
+			notifier: n,
+			queue:    make(chan Alert, queueSize),
+			done:     make(chan struct{}),
+		}
+		e.receivers[rc.Name] = r
+		go r.run()
+	}
+	return e, nil
+}
+
+// Publish takes a canary.Measurement and evaluates every rule that applies
+// to its target.  Measurements in maintenance are ignored: alerts neither
+// fire nor resolve during maintenance.
+func (e *Engine) Publish(m sensor.Measurement) (err error) {
+	if m.InMaintenance {
+		return
+	}
+	now := measuredAt(m)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rule := range e.config.Rules {
+		if !hasTags(m.Target, rule.Tags) {
+			continue
+		}
+
+		key := stateKey{rule.Name, m.Target.Name}
+		s, ok := e.states[key]
+		if !ok {
+			s = &ruleState{}
+			e.states[key] = s
+		}
+		s.observe(rule, m, now)
+
+		holds, summary, known := rule.evaluate(s, m)
+		if !known {
+			continue
+		}
+
+		switch {
+		case holds && !s.firing:
+			s.firing, s.clear = true, 0
+			s.alert = Alert{
+				Rule:        rule.Name,
+				Status:      Firing,
+				Target:      m.Target,
+				Summary:     summary,
+				StartsAt:    now,
+				Measurement: m,
+				Fingerprint: fingerprint(rule.Name, m.Target),
+			}
+			s.notified = now
+			e.notify(rule, s.alert)
+
+		case holds:
+			s.clear = 0
+			s.alert.Summary, s.alert.Measurement = summary, m
+			if rule.RepeatInterval.Duration > 0 && now.Sub(s.notified) >= rule.RepeatInterval.Duration {
+				s.notified = now
+				e.notify(rule, s.alert)
+			}
+
+		case s.firing:
+			s.clear++
+			if s.clear >= max(rule.ResolveAfter, 1) {
+				s.firing = false
+				s.alert.Status, s.alert.EndsAt = Resolved, now
+				s.alert.Summary, s.alert.Measurement = summary, m
+				e.notify(rule, s.alert)
+			}
+		}
+	}
+	return
+}
+
+// RemoveTarget resolves the alerts of a target that is no longer monitored,
+// and drops its state.
+func (e *Engine) RemoveTarget(t sampler.Target) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rule := range e.config.Rules {
+		e.remove(rule, t)
+	}
+}
+
+// ReplaceTarget keeps the alerts of a target whose settings changed, as
+// they share its name, and hands them the new target.  Those of rules that
+// no longer apply to it are resolved, as for a removed target.
+func (e *Engine) ReplaceTarget(old, changed sampler.Target) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rule := range e.config.Rules {
+		if !hasTags(changed, rule.Tags) {
+			e.remove(rule, old)
+			continue
+		}
+		if s, ok := e.states[stateKey{rule.Name, old.Name}]; ok {
+			s.alert.Target = changed
+		}
+	}
+}
+
+// remove resolves the alert of a rule for a target that is no longer
+// monitored, and drops its state.  e.mu must be held.
+func (e *Engine) remove(rule Rule, t sampler.Target) {
+	key := stateKey{rule.Name, t.Name}
+	s, ok := e.states[key]
+	if !ok {
+		return
+	}
+	if s.firing {
+		s.alert.Status, s.alert.EndsAt = Resolved, time.Now()
+		s.alert.Summary = fmt.Sprintf("%s is no longer monitored", targetName(t))
+		e.notify(rule, s.alert)
+	}
+	delete(e.states, key)
+}
+
+// Alerts returns the alerts firing, oldest first.
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var alerts []Alert
+	for _, s := range e.states {
+		if s.firing {
+			alerts = append(alerts, s.alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].StartsAt.Equal(alerts[j].StartsAt) {
+			return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+		}
+		return alerts[i].Fingerprint < alerts[j].Fingerprint
+	})
+	return alerts
+}
+
+// Close delivers the alerts already queued, and stops the receivers.
+func (e *Engine) Close() {
+	for _, r := range e.receivers {
+		close(r.queue)
+	}
+	for _, r := range e.receivers {
+		<-r.done
+	}
+}
+
+// notify queues the alert for each receiver it is routed to, once.
+func (e *Engine) notify(rule Rule, a Alert) {
+	for _, name := range e.route(rule, a.Target) {
+		r := e.receivers[name]
+		select {
+		case r.queue <- a:
+		default:
+			log.Printf("alert: queue of receiver %s is full, dropping %s %s alert for %s", name, a.Status, a.Rule, targetName(a.Target))
+		}
+	}
+}
+
+// route returns the receivers of a rule's alerts for a target, without
+// duplicates.
+func (e *Engine) route(rule Rule, t sampler.Target) []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(receivers []string) {
+		for _, name := range receivers {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	for _, r := range e.config.Routes {
+		if !hasTags(t, r.Tags) || (len(r.Rules) > 0 && !contains(r.Rules, rule.Name)) {
+			continue
+		}
+		add(r.Receivers)
+		if !r.Continue {
+			return names
+		}
+	}
+	if len(names) == 0 {
+		add(e.config.DefaultReceivers)
+	}
+	return names
+}
+
+func (r *receiver) run() {
+	defer close(r.done)
+	for a := range r.queue {
+		if err := r.notifier.Notify(a); err != nil {
+			log.Printf("alert: notifying %s of %s %s alert for %s: %s", r.name, a.Status, a.Rule, targetName(a.Target), err)
+		}
+	}
+}
+
+// observe records a measurement in the rule's window, dropping
+// observations that have fallen out of it.  Error rates count the samples
+// that failed, whether or not the failure was confirmed.
+func (s *ruleState) observe(rule Rule, m sensor.Measurement, now time.Time) {
+	if rule.Window.Duration <= 0 {
+		return
+	}
+
+	o := observation{at: now, ok: m.SampleOK}
+	if !m.Sample.TimeStart.IsZero() && !m.Sample.TimeEnd.IsZero() {
+		o.latency = m.Sample.TimeEnd.Sub(m.Sample.TimeStart)
+	}
+	s.window = append(s.window, o)
+
+	i := 0
+	for i < len(s.window) && now.Sub(s.window[i].at) >= rule.Window.Duration {
+		i++
+	}
+	s.window = s.window[i:]
+}
+
+// evaluate reports whether the rule's condition holds, and describes it.
+// known is false if there are too few samples to tell, in which case the
+// alert is left as it is.
+func (rule Rule) evaluate(s *ruleState, m sensor.Measurement) (holds bool, summary string, known bool) {
+	target := targetName(m.Target)
+	minSamples := max(rule.MinSamples, 1)
+
+	switch {
+	case rule.ConsecutiveDown > 0:
+		if m.IsOK {
+			return false, fmt.Sprintf("%s is up", target), true
+		}
+		summary = fmt.Sprintf("%s has been down for %d consecutive samples", target, m.StateCount)
+		if m.Error != nil {
+			summary += ": " + m.Error.Error()
+		}
+		// once firing, the alert only resolves once the target is up
+		return s.firing || m.StateCount >= rule.ConsecutiveDown, summary, true
+
+	case rule.ErrorRate > 0:
+		if len(s.window) < minSamples {
+			return false, "", false
+		}
+		failed := 0
+		for _, o := range s.window {
+			if !o.ok {
+				failed++
+			}
+		}
+		rate := float64(failed) / float64(len(s.window)) * 100
+		summary = fmt.Sprintf("%.0f%% of %d samples of %s failed in the last %s", rate, len(s.window), target, rule.Window.Duration)
+		return rate > rule.ErrorRate, summary, true
+
+	case rule.LatencyP95.Duration > 0:
+		var latencies []time.Duration
+		for _, o := range s.window {
+			if o.latency > 0 {
+				latencies = append(latencies, o.latency)
+			}
+		}
+		if len(latencies) < minSamples {
+			return false, "", false
+		}
+		p95 := percentile(latencies, 95)
+		summary = fmt.Sprintf("p95 latency of %s is %s over the last %s, the limit is %s", target, p95, rule.Window.Duration, rule.LatencyP95.Duration)
+		return p95 > rule.LatencyP95.Duration, summary, true
+	}
+	return false, "", false
+}
+
+// percentile returns the p-th percentile of ds, by the nearest-rank method.
+func percentile(ds []time.Duration, p float64) time.Duration {
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func hasTags(t sampler.Target, tags []string) bool {
+	for _, tag := range tags {
+		if !contains(t.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// targetName returns the name of a target, or its URL if it has none.
+func targetName(t sampler.Target) string {
+	if t.Name != "" || t.URL.URL == nil {
+		return t.Name
+	}
+	return t.URL.String()
+}
@@ -0,0 +1,293 @@
+package alert
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+// recorder is a Notifier that records the alerts it is notified of.
+type recorder struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *recorder) Notify(a Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+var t0, _ = time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+
+func target(name string, tags ...string) sampler.Target {
+	return sampler.Target{Name: name, Hash: name, Tags: tags}
+}
+
+// measurement returns a sample of t taken at, lasting latency.
+func measurement(t sampler.Target, ok bool, count int, at, latency time.Duration) sensor.Measurement {
+	m := sensor.Measurement{
+		Target: t,
+		Sample: sampler.Sample{
+			TimeStart: t0.Add(at),
+			TimeEnd:   t0.Add(at + latency),
+		},
+		IsOK:       ok,
+		SampleOK:   ok,
+		StateCount: count,
+	}
+	if !ok {
+		m.Error = &sampler.StatusCodeError{StatusCode: 503}
+	}
+	return m
+}
+
+func newEngine(t *testing.T, config Config, receivers ...string) (*Engine, map[string]*recorder) {
+	recorders := make(map[string]*recorder)
+	notifiers := make(map[string]Notifier)
+	for _, name := range receivers {
+		recorders[name] = &recorder{}
+		notifiers[name] = recorders[name]
+		config.Receivers = append(config.Receivers, ReceiverConfig{Name: name, Type: "test"})
+	}
+	e, err := New(config, notifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, recorders
+}
+
+func statuses(alerts []Alert) []Status {
+	var s []Status
+	for _, a := range alerts {
+		s = append(s, a.Status)
+	}
+	return s
+}
+
+func TestConsecutiveDown(t *testing.T) {
+	e, r := newEngine(t, Config{
+		Rules:            []Rule{{Name: "down", ConsecutiveDown: 3, ResolveAfter: 2}},
+		DefaultReceivers: []string{"ops"},
+	}, "ops")
+
+	www := target("www")
+	for i, m := range []sensor.Measurement{
+		measurement(www, false, 1, 0, 0),
+		measurement(www, false, 2, time.Second, 0),
+		measurement(www, false, 3, 2*time.Second, 0), // fires
+		measurement(www, false, 4, 3*time.Second, 0), // still firing, not notified again
+		measurement(www, true, 1, 4*time.Second, 0),  // recovering
+		measurement(www, false, 1, 5*time.Second, 0), // down again, so still firing
+		measurement(www, true, 1, 6*time.Second, 0),
+		measurement(www, true, 2, 7*time.Second, 0), // resolves
+	} {
+		e.Publish(m)
+		if firing, expected := len(e.Alerts()) == 1, i >= 2 && i < 7; firing != expected {
+			t.Fatalf("after measurement %d, expected firing to be %t", i, expected)
+		}
+	}
+	e.Close()
+
+	alerts := r["ops"].alerts
+	if len(alerts) != 2 || alerts[0].Status != Firing || alerts[1].Status != Resolved {
+		t.Fatalf("expected the alert to fire and resolve once, got %v", statuses(alerts))
+	}
+	if a := alerts[0]; !a.StartsAt.Equal(t0.Add(2*time.Second)) || a.Summary != "www has been down for 3 consecutive samples: recieved HTTP status 503" {
+		t.Errorf("unexpected firing alert: %+v", a)
+	}
+	if a := alerts[1]; !a.EndsAt.Equal(t0.Add(7*time.Second)) || a.Fingerprint != alerts[0].Fingerprint {
+		t.Errorf("expected the resolved alert to end at the last sample, with the same fingerprint, got %+v", a)
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	e, r := newEngine(t, Config{
+		Rules:            []Rule{{Name: "errors", ErrorRate: 50, Window: Duration{time.Minute}, MinSamples: 4}},
+		DefaultReceivers: []string{"ops"},
+	}, "ops")
+
+	www := target("www")
+	results := []bool{false, false, false, true, true, true}
+	for i, ok := range results {
+		e.Publish(measurement(www, ok, 1, time.Duration(i)*15*time.Second, 0))
+	}
+	e.Close()
+
+	// fires once 3 of the first 4 samples failed, and resolves once the
+	// first failure falls out of the window
+	alerts := r["ops"].alerts
+	if len(alerts) != 2 || !alerts[0].StartsAt.Equal(t0.Add(45*time.Second)) || !alerts[1].EndsAt.Equal(t0.Add(60*time.Second)) {
+		t.Fatalf("expected the alert to fire at 45s and resolve at 60s, got %+v", alerts)
+	}
+	if s := alerts[0].Summary; s != "75% of 4 samples of www failed in the last 1m0s" {
+		t.Errorf("unexpected summary: %s", s)
+	}
+}
+
+func TestErrorRateCountsUnconfirmedFailures(t *testing.T) {
+	e, r := newEngine(t, Config{
+		Rules:            []Rule{{Name: "errors", ErrorRate: 50, Window: Duration{time.Minute}, MinSamples: 3}},
+		DefaultReceivers: []string{"ops"},
+	}, "ops")
+
+	// two samples out of three fail, never enough in a row to confirm
+	www := target("www")
+	for i, ok := range []bool{false, false, true} {
+		m := measurement(www, true, i+1, time.Duration(i)*time.Second, 0)
+		m.SampleOK = ok
+		e.Publish(m)
+	}
+	e.Close()
+
+	if alerts := r["ops"].alerts; len(alerts) != 1 || alerts[0].Status != Firing {
+		t.Fatalf("expected the alert to fire on unconfirmed failures, got %v", statuses(alerts))
+	}
+}
+
+func TestLatencyP95(t *testing.T) {
+	e, r := newEngine(t, Config{
+		Rules:            []Rule{{Name: "slow", LatencyP95: Duration{time.Second}, Window: Duration{time.Hour}}},
+		DefaultReceivers: []string{"ops"},
+	}, "ops")
+
+	www := target("www")
+	// 20 fast samples, then 2 slow ones put the p95 above a second
+	for i := 0; i < 22; i++ {
+		latency := 100 * time.Millisecond
+		if i >= 20 {
+			latency = 2 * time.Second
+		}
+		e.Publish(measurement(www, true, i+1, time.Duration(i)*time.Second, latency))
+		if firing := len(e.Alerts()) > 0; firing != (i == 21) {
+			t.Fatalf("after sample %d, expected firing to be %t", i, i == 21)
+		}
+	}
+	e.Close()
+
+	if alerts := r["ops"].alerts; len(alerts) != 1 || !strings.HasPrefix(alerts[0].Summary, "p95 latency of www is 2s") {
+		t.Fatalf("expected a single alert, got %+v", alerts)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	e, r := newEngine(t, Config{
+		Rules: []Rule{
+			{Name: "down", ConsecutiveDown: 1},
+			{Name: "prod-down", Tags: []string{"prod"}, ConsecutiveDown: 1},
+		},
+		Routes: []Route{
+			{Tags: []string{"prod"}, Receivers: []string{"oncall"}, Continue: true},
+			{Tags: []string{"prod"}, Rules: []string{"down"}, Receivers: []string{"oncall", "team"}},
+			{Tags: []string{"web"}, Receivers: []string{"team"}},
+		},
+		DefaultReceivers: []string{"log"},
+	}, "oncall", "team", "log")
+
+	e.Publish(measurement(target("api", "prod"), false, 1, 0, 0))
+	e.Publish(measurement(target("www", "web"), false, 1, 0, 0))
+	e.Publish(measurement(target("docs"), false, 1, 0, 0))
+	e.Close()
+
+	count := func(name string) map[string]int {
+		c := make(map[string]int)
+		for _, a := range r[name].alerts {
+			c[a.Target.Name+"/"+a.Rule]++
+		}
+		return c
+	}
+
+	// api's down alert matches both prod routes, but reaches oncall once
+	if c := count("oncall"); len(c) != 2 || c["api/down"] != 1 || c["api/prod-down"] != 1 {
+		t.Errorf("expected oncall to be notified of both of api's alerts once, got %v", c)
+	}
+	if c := count("team"); len(c) != 2 || c["api/down"] != 1 || c["www/down"] != 1 {
+		t.Errorf("expected team to be notified of api's down and www's alerts, got %v", c)
+	}
+	if c := count("log"); len(c) != 1 || c["docs/down"] != 1 {
+		t.Errorf("expected unrouted alerts to reach the default receiver, got %v", c)
+	}
+}
+
+func TestRepeatAndRemoveTarget(t *testing.T) {
+	e, r := newEngine(t, Config{
+		Rules:            []Rule{{Name: "down", ConsecutiveDown: 1, RepeatInterval: Duration{time.Minute}}},
+		DefaultReceivers: []string{"ops"},
+	}, "ops")
+
+	www := target("www")
+	for i := 0; i < 4; i++ {
+		e.Publish(measurement(www, false, i+1, time.Duration(i)*30*time.Second, 0))
+	}
+	e.RemoveTarget(www)
+	e.Close()
+
+	// fires at 0s, repeats at 60s, and resolves once the target is removed
+	expected := []Status{Firing, Firing, Resolved}
+	if s := statuses(r["ops"].alerts); len(s) != 3 || s[0] != expected[0] || s[1] != expected[1] || s[2] != expected[2] {
+		t.Fatalf("expected %v, got %v", expected, s)
+	}
+	if len(e.Alerts()) != 0 {
+		t.Errorf("expected no alerts firing for a removed target")
+	}
+}
+
+func TestReplaceTarget(t *testing.T) {
+	e, r := newEngine(t, Config{
+		Rules: []Rule{
+			{Name: "down", ConsecutiveDown: 1},
+			{Name: "prod-down", ConsecutiveDown: 1, Tags: []string{"prod"}},
+		},
+		DefaultReceivers: []string{"ops"},
+	}, "ops")
+
+	www := target("www", "prod")
+	e.Publish(measurement(www, false, 1, 0, 0))
+
+	// the interval changes, and the prod tag is dropped
+	changed := www
+	changed.Hash, changed.Interval, changed.Tags = "www-v2", 5, nil
+	e.ReplaceTarget(www, changed)
+	e.Publish(measurement(changed, false, 1, time.Second, 0))
+	e.Close()
+
+	alerts := r["ops"].alerts
+	if len(alerts) != 3 || alerts[2].Rule != "prod-down" || alerts[2].Status != Resolved {
+		t.Fatalf("expected both alerts to fire, and only prod-down to resolve, got %v", statuses(alerts))
+	}
+	firing := e.Alerts()
+	if len(firing) != 1 || firing[0].Target.Hash != "www-v2" {
+		t.Fatalf("expected the down alert to keep firing for the changed target, got %+v", firing)
+	}
+	if alerts[0].Rule != "down" || firing[0].Fingerprint != alerts[0].Fingerprint {
+		t.Errorf("expected the fingerprint to survive the change, got %s and %s", alerts[0].Fingerprint, firing[0].Fingerprint)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	e, r := newEngine(t, Config{
+		Rules:            []Rule{{Name: "down", ConsecutiveDown: 2}},
+		DefaultReceivers: []string{"ops"},
+	}, "ops")
+
+	www := target("www")
+	for i := 0; i < 5; i++ {
+		m := measurement(www, false, i+1, time.Duration(i)*time.Second, 0)
+		m.InMaintenance = true
+		e.Publish(m)
+	}
+	if alerts := e.Alerts(); len(alerts) != 0 {
+		t.Fatalf("expected no alert during maintenance, got %d", len(alerts))
+	}
+
+	e.Publish(measurement(www, false, 6, 5*time.Second, 0))
+	e.Close()
+	if alerts := r["ops"].alerts; len(alerts) != 1 || alerts[0].Status != Firing {
+		t.Fatalf("expected the alert to fire after maintenance, got %v", statuses(alerts))
+	}
+}
@@ -0,0 +1,78 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxRetries is how many times PostJSON retries a failed request.
+const MaxRetries = 3
+
+// RetryDelay is the delay before PostJSON's first retry, doubling for each
+// retry.
+var RetryDelay = time.Second
+
+// PostJSON posts v, encoded as JSON, to url for notifiers of HTTP APIs.
+// Requests that fail, or are answered with a 429 or 5xx status, are
+// retried up to MaxRetries times with backoff.  Other error statuses are
+// returned at once, with the start of the response body.
+func PostJSON(client *http.Client, url string, header http.Header, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	delay := RetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := post(client, url, header, body)
+		if err == nil || !retry || attempt == MaxRetries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func post(client *http.Client, url string, header http.Header, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	text, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("received HTTP status %d: %s", resp.StatusCode, bytes.TrimSpace(text))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5, err
+}
+
+// Truncate shortens s to at most n bytes for APIs that limit the length of
+// fields, ending it with "..." if it was cut.  It only cuts between whole
+// characters, so that the result is still valid UTF-8.
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := n - 3
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
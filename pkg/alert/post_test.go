@@ -0,0 +1,25 @@
+package alert
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	for _, c := range []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{"api is down", 20, "api is down"},
+		{"api is down", 9, "api is..."},
+		// "é" takes two bytes, and is not split
+		{"café is down", 7, "caf..."},
+		{"café is down", 8, "café..."},
+	} {
+		got := Truncate(c.s, c.n)
+		if got != c.expected || !utf8.ValidString(got) {
+			t.Errorf("expected Truncate(%q, %d) to be %q, got %q", c.s, c.n, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package emailnotifier
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canaryio/canary/pkg/alert"
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+const (
+	// RecipientsAttribute is the target attribute holding the addresses
+	// notified of that target's alerts, separated by commas.
+	RecipientsAttribute = "email"
+
+	// DefaultPort is the port of the SMTP server if none is configured.
+	DefaultPort = 25
+
+	// DefaultDigestWindow is how long alerts are collected for a digest if
+	// digest_window is unset.
+	DefaultDigestWindow = 30 * time.Second
+
+	dialTimeout = 10 * time.Second
+)
+
+// STARTTLS modes.
+const (
+	StartTLSAuto   = "auto"   // upgrade if the server offers STARTTLS
+	StartTLSAlways = "always" // fail if the server does not offer it
+	StartTLSNever  = "never"
+)
+
+// Settings configure a Notifier, as the settings of an email receiver.
+type Settings struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+
+	StartTLS           string `json:"starttls"` // auto, always or never, defaults to auto
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+
+	// Username and Password authenticate with AUTH PLAIN, if set.
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	From string   `json:"from"`
+	To   []string `json:"to"` // for targets without recipients of their own
+
+	// Recipients maps tags to addresses: alerts of a target with the tag
+	// are sent to them, as well as to the target's email attribute.
+	Recipients map[string][]string `json:"recipients"`
+
+	// Alerts within DigestWindow of the first are sent together, as one
+	// email to each set of recipients.
+	DigestWindow alert.Duration `json:"digest_window"`
+}
+
+// Notifier implements the alert.Notifier interface, and emails alerts that
+// fire or are resolved over SMTP.
+type Notifier struct {
+	Settings
+
+	mu      sync.Mutex
+	pending []alert.Alert
+	timer   *time.Timer
+}
+
+// New returns a pointer to a new Notifier.
+func New(settings Settings) (*Notifier, error) {
+	if settings.Host == "" || settings.From == "" {
+		return nil, fmt.Errorf("email: host and from must be set")
+	}
+	if settings.Port == 0 {
+		settings.Port = DefaultPort
+	}
+	switch settings.StartTLS {
+	case "":
+		settings.StartTLS = StartTLSAuto
+	case StartTLSAuto, StartTLSAlways, StartTLSNever:
+	default:
+		return nil, fmt.Errorf("email: unknown starttls %q, must be auto, always or never", settings.StartTLS)
+	}
+	if settings.DigestWindow.Duration <= 0 {
+		settings.DigestWindow.Duration = DefaultDigestWindow
+	}
+	return &Notifier{Settings: settings}, nil
+}
+
+// NewFromSettings is a convenience func that wraps New, and parses its
+// settings from the JSON object of a receiver.
+func NewFromSettings(raw json.RawMessage) (*Notifier, error) {
+	var s Settings
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("email: %s", err)
+	}
+	return New(s)
+}
+
+// Notify queues the alert for the digest being collected, starting one if
+// there is none.
+func (n *Notifier) Notify(a alert.Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.pending = append(n.pending, a)
+	if n.timer == nil {
+		n.timer = time.AfterFunc(n.DigestWindow.Duration, func() {
+			if err := n.Flush(); err != nil {
+				log.Print(err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush sends the alerts collected so far, without waiting for the end of
+// the digest window.
+func (n *Notifier) Flush() error {
+	n.mu.Lock()
+	pending := n.pending
+	n.pending = nil
+	if n.timer != nil {
+		n.timer.Stop()
+		n.timer = nil
+	}
+	n.mu.Unlock()
+
+	// group the alerts by their recipients
+	groups := make(map[string][]alert.Alert)
+	var keys []string
+	for _, a := range pending {
+		to := n.recipients(a.Target)
+		if len(to) == 0 {
+			log.Printf("email: no recipients for %s alert of %s", a.Rule, a.Target.Name)
+			continue
+		}
+		key := strings.Join(to, ",")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], a)
+	}
+
+	var errs []string
+	for _, key := range keys {
+		to := strings.Split(key, ",")
+		if err := n.send(to, n.compose(to, groups[key])); err != nil {
+			errs = append(errs, fmt.Sprintf("sending %d alerts to %s: %s", len(groups[key]), key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("email: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// recipients returns the sorted addresses notified of a target's alerts.
+func (n *Notifier) recipients(t sampler.Target) []string {
+	seen := make(map[string]bool)
+	add := func(addrs ...string) {
+		for _, addr := range addrs {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				seen[addr] = true
+			}
+		}
+	}
+	if attr := t.Attributes[RecipientsAttribute]; attr != "" {
+		add(strings.Split(attr, ",")...)
+	}
+	for _, tag := range t.Tags {
+		add(n.Recipients[tag]...)
+	}
+	if len(seen) == 0 {
+		add(n.To...)
+	}
+
+	to := make([]string, 0, len(seen))
+	for addr := range seen {
+		to = append(to, addr)
+	}
+	sort.Strings(to)
+	return to
+}
+
+// compose renders the email of one or more alerts.
+func (n *Notifier) compose(to []string, alerts []alert.Alert) []byte {
+	var subject string
+	if len(alerts) == 1 {
+		a := alerts[0]
+		subject = fmt.Sprintf("[%s] %s: %s", strings.ToUpper(string(a.Status)), a.Rule, a.Target.Name)
+	} else {
+		firing := 0
+		for _, a := range alerts {
+			if a.Status == alert.Firing {
+				firing++
+			}
+		}
+		subject = fmt.Sprintf("[canary] %d alerts: %d firing, %d resolved", len(alerts), firing, len(alerts)-firing)
+	}
+
+	var body bytes.Buffer
+	qp := quotedprintable.NewWriter(&body)
+	for i, a := range alerts {
+		if i > 0 {
+			fmt.Fprint(qp, "\r\n")
+		}
+		writeAlert(qp, a)
+	}
+	qp.Close()
+
+	var msg bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&msg, "%s: %s\r\n", k, v) }
+	header("From", n.From)
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "quoted-printable")
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes()
+}
+
+// writeAlert describes an alert in plain text.
+func writeAlert(w interface{ Write([]byte) (int, error) }, a alert.Alert) {
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, format+"\r\n", args...)
+	}
+
+	if a.Status == alert.Firing {
+		line("DOWN: %s", a.Target.Name)
+	} else {
+		line("RECOVERED: %s", a.Target.Name)
+	}
+	line("%s", a.Summary)
+	line("")
+	line("Rule:    %s", a.Rule)
+	if a.Target.URL.URL != nil {
+		line("URL:     %s", a.Target.URL.String())
+	}
+	if err := a.Measurement.Error; err != nil {
+		line("Error:   %s", err)
+	}
+	line("Started: %s", a.StartsAt.UTC().Format(time.RFC1123))
+	if a.Status == alert.Resolved {
+		line("Ended:   %s (after %s)", a.EndsAt.UTC().Format(time.RFC1123), a.EndsAt.Sub(a.StartsAt).Round(time.Second))
+	}
+}
+
+// send delivers a message, retrying failures with backoff.
+func (n *Notifier) send(to []string, msg []byte) (err error) {
+	delay := alert.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err = n.sendOnce(to, msg)
+		if err == nil || attempt == alert.MaxRetries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (n *Notifier) sendOnce(to []string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(n.Host, strconv.Itoa(n.Port)), dialTimeout)
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && n.StartTLS != StartTLSNever {
+		if err := c.StartTLS(&tls.Config{ServerName: n.Host, InsecureSkipVerify: n.InsecureSkipVerify}); err != nil {
+			return err
+		}
+	} else if n.StartTLS == StartTLSAlways {
+		return fmt.Errorf("%s does not offer STARTTLS", n.Host)
+	}
+
+	if n.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, n.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,357 @@
+package emailnotifier
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/alert"
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+// email is a message received by a sink.
+type email struct {
+	from    string
+	to      []string
+	auth    string // the decoded AUTH PLAIN response
+	tls     bool
+	subject string
+	body    string
+}
+
+// sink is a minimal SMTP server recording the messages sent to it.
+type sink struct {
+	ln       net.Listener
+	tls      *tls.Config // offers STARTTLS if set
+	mu       sync.Mutex
+	messages []email
+}
+
+func newSink(t *testing.T, starttls bool) *sink {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sink{ln: ln}
+	if starttls {
+		s.tls = &tls.Config{Certificates: []tls.Certificate{certificate(t)}}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *sink) Close() { s.ln.Close() }
+
+func (s *sink) settings() string {
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	return `"host": "127.0.0.1", "port": ` + port + `, "from": "canary@example.com"`
+}
+
+func (s *sink) received() []email {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]email(nil), s.messages...)
+}
+
+func (s *sink) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for _, l := range lines {
+			conn.Write([]byte(l + "\r\n"))
+		}
+	}
+
+	var e email
+	reply("220 sink ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+		switch {
+		case verb == "EHLO":
+			if s.tls != nil && !e.tls {
+				reply("250-sink", "250-STARTTLS", "250 AUTH PLAIN")
+			} else {
+				reply("250-sink", "250 AUTH PLAIN")
+			}
+		case verb == "STARTTLS":
+			reply("220 go ahead")
+			tc := tls.Server(conn, s.tls)
+			if tc.Handshake() != nil {
+				return
+			}
+			conn, r, e.tls = tc, bufio.NewReader(tc), true
+		case verb == "AUTH":
+			b, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(cmd, "AUTH PLAIN "))
+			e.auth = string(b)
+			reply("235 authenticated")
+		case strings.HasPrefix(verb, "MAIL"):
+			e.from = addr(cmd)
+			reply("250 ok")
+		case strings.HasPrefix(verb, "RCPT"):
+			e.to = append(e.to, addr(cmd))
+			reply("250 ok")
+		case verb == "DATA":
+			reply("354 send data")
+			m, err := mail.ReadMessage(&dotReader{r: r})
+			if err != nil {
+				return
+			}
+			body, _ := ioutil.ReadAll(quotedprintable.NewReader(m.Body))
+			e.subject, _ = new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+			e.body = string(body)
+			s.mu.Lock()
+			s.messages = append(s.messages, e)
+			s.mu.Unlock()
+			reply("250 queued")
+		case verb == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func addr(cmd string) string {
+	return strings.Trim(cmd[strings.Index(cmd, ":")+1:], "<> ")
+}
+
+// dotReader reads the data of a message, up to the line with a single dot.
+type dotReader struct {
+	r    *bufio.Reader
+	done bool
+	buf  []byte
+}
+
+func (d *dotReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		line, err := d.r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		if line == ".\r\n" {
+			d.done = true
+			continue
+		}
+		d.buf = []byte(strings.TrimPrefix(line, "."))
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// certificate returns a self-signed certificate for 127.0.0.1.
+func certificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newAlert(name string, status alert.Status, err error, tags ...string) alert.Alert {
+	u, _ := sampler.NewJsonURL("https://" + name + ".canary.io")
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	a := alert.Alert{
+		Rule:        "down",
+		Status:      status,
+		Target:      sampler.Target{URL: *u, Name: name, Tags: tags},
+		Summary:     name + " has been down for 3 consecutive samples",
+		StartsAt:    t1,
+		Measurement: sensor.Measurement{Error: err},
+	}
+	if status == alert.Resolved {
+		a.EndsAt = t1.Add(5 * time.Minute)
+	}
+	return a
+}
+
+func TestNotify(t *testing.T) {
+	s := newSink(t, false)
+	defer s.Close()
+
+	n, err := NewFromSettings(json.RawMessage(`{` + s.settings() + `, "to": ["ops@example.com"], "digest_window": "50ms"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(newAlert("www", alert.Firing, errors.New("connecting: i/o timeout")))
+
+	time.Sleep(250 * time.Millisecond)
+	emails := s.received()
+	if len(emails) != 1 {
+		t.Fatalf("want 1 email, got %d", len(emails))
+	}
+	e := emails[0]
+
+	if e.from != "canary@example.com" || strings.Join(e.to, ",") != "ops@example.com" {
+		t.Errorf("want from canary@example.com to ops@example.com, got %s to %v", e.from, e.to)
+	}
+	if e.subject != "[FIRING] down: www" {
+		t.Errorf("want subject [FIRING] down: www, got %q", e.subject)
+	}
+	for _, want := range []string{"DOWN: www", "https://www.canary.io", "Error:   connecting: i/o timeout"} {
+		if !strings.Contains(e.body, want) {
+			t.Errorf("want body containing %q, got %q", want, e.body)
+		}
+	}
+	if e.tls || e.auth != "" {
+		t.Errorf("want neither STARTTLS nor AUTH, got tls %v, auth %q", e.tls, e.auth)
+	}
+}
+
+func TestStartTLSAndAuth(t *testing.T) {
+	s := newSink(t, true)
+	defer s.Close()
+
+	n, err := NewFromSettings(json.RawMessage(`{` + s.settings() + `, "to": ["ops@example.com"],
+		"starttls": "always", "insecure_skip_verify": true, "username": "canary", "password": "secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(newAlert("www", alert.Resolved, nil))
+	if err := n.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	emails := s.received()
+	if len(emails) != 1 {
+		t.Fatalf("want 1 email, got %d", len(emails))
+	}
+	if !emails[0].tls {
+		t.Error("want STARTTLS")
+	}
+	if want := "\x00canary\x00secret"; emails[0].auth != want {
+		t.Errorf("want AUTH PLAIN %q, got %q", want, emails[0].auth)
+	}
+	if !strings.Contains(emails[0].body, "RECOVERED: www") || !strings.Contains(emails[0].body, "after 5m0s") {
+		t.Errorf("want a recovery, got %q", emails[0].body)
+	}
+}
+
+func TestStartTLSRequired(t *testing.T) {
+	defer func(d time.Duration) { alert.RetryDelay = d }(alert.RetryDelay)
+	alert.RetryDelay = time.Millisecond
+
+	s := newSink(t, false)
+	defer s.Close()
+
+	n, _ := NewFromSettings(json.RawMessage(`{` + s.settings() + `, "to": ["ops@example.com"], "starttls": "always"}`))
+	n.Notify(newAlert("www", alert.Firing, nil))
+	if err := n.Flush(); err == nil || !strings.Contains(err.Error(), "does not offer STARTTLS") {
+		t.Errorf("want a STARTTLS error, got %v", err)
+	}
+	if emails := s.received(); len(emails) != 0 {
+		t.Errorf("want no email, got %d", len(emails))
+	}
+}
+
+func TestDigest(t *testing.T) {
+	s := newSink(t, false)
+	defer s.Close()
+
+	n, _ := NewFromSettings(json.RawMessage(`{` + s.settings() + `, "to": ["ops@example.com"],
+		"recipients": {"db": ["dba@example.com"]}}`))
+
+	www := newAlert("www", alert.Firing, nil, "prod")
+	api := newAlert("api", alert.Firing, nil)
+	db := newAlert("db", alert.Firing, nil, "prod", "db")
+	db.Target.Attributes = map[string]string{RecipientsAttribute: "db-team@example.com, dba@example.com"}
+	for _, a := range []alert.Alert{www, api, db, newAlert("www", alert.Resolved, nil, "prod")} {
+		n.Notify(a)
+	}
+	if err := n.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	emails := s.received()
+	if len(emails) != 2 {
+		t.Fatalf("want 2 emails, got %d", len(emails))
+	}
+	byTo := make(map[string]email)
+	for _, e := range emails {
+		byTo[strings.Join(e.to, ",")] = e
+	}
+
+	ops, ok := byTo["ops@example.com"]
+	if !ok {
+		t.Fatalf("want an email to ops@example.com, got %v", byTo)
+	}
+	if want := "[canary] 3 alerts: 2 firing, 1 resolved"; ops.subject != want {
+		t.Errorf("want subject %q, got %q", want, ops.subject)
+	}
+	if strings.Count(ops.body, "DOWN:") != 2 || strings.Count(ops.body, "RECOVERED:") != 1 {
+		t.Errorf("want 2 down and 1 recovered, got %q", ops.body)
+	}
+
+	dba, ok := byTo["db-team@example.com,dba@example.com"]
+	if !ok {
+		t.Fatalf("want an email to the db team, got %v", byTo)
+	}
+	if dba.subject != "[FIRING] down: db" {
+		t.Errorf("want subject [FIRING] down: db, got %q", dba.subject)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, settings := range []string{
+		`{"from": "canary@example.com"}`,
+		`{"host": "smtp.example.com"}`,
+		`{"host": "smtp.example.com", "from": "canary@example.com", "starttls": "sometimes"}`,
+	} {
+		if _, err := NewFromSettings(json.RawMessage(settings)); err == nil {
+			t.Errorf("want an error for %s", settings)
+		}
+	}
+
+	n, err := NewFromSettings(json.RawMessage(`{"host": "smtp.example.com", "from": "canary@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Port != DefaultPort || n.StartTLS != StartTLSAuto || n.DigestWindow.Duration != DefaultDigestWindow {
+		t.Errorf("want defaults, got port %d, starttls %s, digest window %s", n.Port, n.StartTLS, n.DigestWindow.Duration)
+	}
+}
@@ -0,0 +1,137 @@
+// Package filewatch notifies callers when a file is changed on disk.
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// the events that indicate the watched file has new contents, either
+// written in place or atomically moved or created over the old one.
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// Watcher sends on C whenever the watched file changes.
+type Watcher struct {
+	C    <-chan struct{}
+	file *os.File
+}
+
+// New watches the file at path using inotify.  The parent directory is
+// watched, so that atomic renames over the file are noticed as well as
+// writes to it.  Bursts of events are coalesced: a single notification
+// is sent once no events have arrived for the debounce duration.
+func New(path string, debounce time.Duration) (*Watcher, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, name := filepath.Split(path)
+
+	return newWatcher(dir, func(n string) bool { return n == name }, debounce)
+}
+
+// NewGlob is like New, but watches every file in a single directory whose
+// name matches pattern, as interpreted by filepath.Match.  Only the last
+// element of pattern may contain wildcards.
+func NewGlob(pattern string, debounce time.Duration) (*Watcher, error) {
+	pattern, err := filepath.Abs(pattern)
+	if err != nil {
+		return nil, err
+	}
+	dir, name := filepath.Split(pattern)
+	if _, err := filepath.Match(name, ""); err != nil {
+		return nil, err
+	}
+
+	match := func(n string) bool {
+		ok, _ := filepath.Match(name, n)
+		return ok
+	}
+	return newWatcher(dir, match, debounce)
+}
+
+func newWatcher(dir string, match func(name string) bool, debounce time.Duration) (*Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	_, err = syscall.InotifyAddWatch(fd, dir, watchMask)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	c := make(chan struct{}, 1)
+	w := &Watcher{
+		C:    c,
+		file: os.NewFile(uintptr(fd), "inotify"),
+	}
+
+	events := make(chan struct{})
+	go w.read(match, events)
+	go debounceEvents(events, c, debounce)
+
+	return w, nil
+}
+
+// Close stops the watcher.  No notifications are sent after Close returns.
+func (w *Watcher) Close() error {
+	return w.file.Close()
+}
+
+// read parses inotify events, sending on events for those that name a
+// watched file.  events is closed once the inotify descriptor is closed.
+func (w *Watcher) read(match func(name string) bool, events chan<- struct{}) {
+	defer close(events)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			end := start + int(event.Len)
+			offset = end
+
+			// the name is padded with trailing NULs
+			eventName := string(buf[start:end])
+			for len(eventName) > 0 && eventName[len(eventName)-1] == 0 {
+				eventName = eventName[:len(eventName)-1]
+			}
+
+			if match(eventName) {
+				events <- struct{}{}
+			}
+		}
+	}
+}
+
+// debounceEvents sends a single notification on c once events has been
+// quiet for the debounce duration.
+func debounceEvents(events <-chan struct{}, c chan<- struct{}, debounce time.Duration) {
+	var timer <-chan time.Time
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+			timer = time.After(debounce)
+		case <-timer:
+			timer = nil
+			select {
+			case c <- struct{}{}:
+			default:
+				// a notification is already pending
+			}
+		}
+	}
+}
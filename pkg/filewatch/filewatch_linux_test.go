@@ -0,0 +1,113 @@
+package filewatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const debounce = 50 * time.Millisecond
+
+func expectNotification(t *testing.T, w *Watcher) {
+	select {
+	case <-w.C:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a notification, got none")
+	}
+}
+
+func expectNoNotification(t *testing.T, w *Watcher) {
+	select {
+	case <-w.C:
+		t.Fatal("expected no notification, got one")
+	case <-time.After(4 * debounce):
+	}
+}
+
+func TestWatchWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filewatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "manifest.json")
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(path, debounce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// several writes in a burst result in a single notification
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(path, []byte(`{"targets": []}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectNotification(t, w)
+	expectNoNotification(t, w)
+
+	// changes to other files in the directory are ignored
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectNoNotification(t, w)
+}
+
+func TestWatchRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filewatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "manifest.json")
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(path, debounce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	tmp := filepath.Join(dir, ".manifest.json.tmp")
+	if err := ioutil.WriteFile(tmp, []byte(`{"targets": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	expectNotification(t, w)
+}
+
+func TestWatchGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filewatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewGlob(filepath.Join(dir, "*.json"), debounce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectNoNotification(t, w)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "team-a.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectNotification(t, w)
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+// Package filewatch notifies callers when a file is changed on disk.
+package filewatch
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// Watcher sends on C whenever the watched file changes.
+type Watcher struct {
+	C <-chan struct{}
+}
+
+// New is only supported on Linux.
+func New(path string, debounce time.Duration) (*Watcher, error) {
+	return nil, fmt.Errorf("watching files is not supported on %s", runtime.GOOS)
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() error {
+	return nil
+}
+
+// NewGlob is only supported on Linux.
+func NewGlob(pattern string, debounce time.Duration) (*Watcher, error) {
+	return nil, fmt.Errorf("watching files is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,336 @@
+package graphitepublisher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+const (
+	// DefaultPrefix is the first component of every metric path if
+	// GRAPHITE_PREFIX is unset.
+	DefaultPrefix = "canary"
+
+	// DefaultBufferSize is how many metrics are kept while carbon is
+	// unavailable if GRAPHITE_BUFFER_SIZE is unset.
+	DefaultBufferSize = 10000
+
+	// maxBatch is the most metrics written, or pickled, at once.
+	maxBatch = 500
+
+	writeTimeout = 10 * time.Second
+)
+
+// backoff between attempts to reach carbon.
+var (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Protocol is a carbon listener protocol.
+type Protocol string
+
+const (
+	Plaintext Protocol = "plaintext"
+	Pickle    Protocol = "pickle"
+)
+
+type metric struct {
+	path      string
+	value     float64
+	timestamp int64
+}
+
+// Publisher implements the canary.Publisher interface, and sends
+// measurements to carbon, Graphite's storage backend.  Metrics are sent in
+// the background; while carbon is unavailable they are buffered, up to
+// BufferSize, dropping the oldest once the buffer is full.
+type Publisher struct {
+	addr       string
+	prefix     string
+	protocol   Protocol
+	bufferSize int
+
+	mu      sync.Mutex
+	queue   []metric
+	dropped int // metrics dropped from the front of the queue, ever
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a pointer to a new Publisher sending to carbon at addr.
+func New(addr, prefix string, protocol Protocol, bufferSize int) (*Publisher, error) {
+	if protocol != Plaintext && protocol != Pickle {
+		return nil, fmt.Errorf("unknown graphite protocol %q, must be plaintext or pickle", protocol)
+	}
+
+	p := &Publisher{
+		addr:       addr,
+		prefix:     prefix,
+		protocol:   protocol,
+		bufferSize: bufferSize,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// NewFromEnv is a convenience func that wraps New, and populates its
+// arguments via environment variables.
+func NewFromEnv() (*Publisher, error) {
+	addr := os.Getenv("GRAPHITE_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("GRAPHITE_ADDR not set in ENV")
+	}
+
+	prefix := os.Getenv("GRAPHITE_PREFIX")
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	protocol := Protocol(os.Getenv("GRAPHITE_PROTOCOL"))
+	if protocol == "" {
+		protocol = Plaintext
+	}
+
+	bufferSize := DefaultBufferSize
+	if s := os.Getenv("GRAPHITE_BUFFER_SIZE"); s != "" {
+		var err error
+		bufferSize, err = strconv.Atoi(s)
+		if err != nil || bufferSize <= 0 {
+			return nil, fmt.Errorf("GRAPHITE_BUFFER_SIZE is not a valid positive integer")
+		}
+	}
+
+	return New(addr, prefix, protocol, bufferSize)
+}
+
+// Publish takes a canary.Measurement and queues its metrics to be sent.
+// up is 1 while the target is confirmed up, whatever this sample's result.
+func (p *Publisher) Publish(m sensor.Measurement) (err error) {
+	timestamp := m.Sample.TimeEnd
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	base := p.prefix + "." + Sanitize(m.Target.Name) + "."
+	var metrics []metric
+	add := func(name string, value float64) {
+		metrics = append(metrics, metric{base + name, value, timestamp.Unix()})
+	}
+
+	if !m.Sample.TimeStart.IsZero() {
+		add("latency", m.Sample.TimeEnd.Sub(m.Sample.TimeStart).Seconds()*1000)
+	}
+	switch {
+	case m.InMaintenance:
+		add("maintenance", 1)
+	case m.IsOK:
+		add("up", 1)
+	default:
+		add("up", 0)
+	}
+	if m.Error != nil && !m.InMaintenance {
+		add("errors", 1)
+		switch m.Error.(type) {
+		case sampler.StatusCodeError, *sampler.StatusCodeError:
+			add("errors.http", 1)
+		default:
+			add("errors.sampler", 1)
+		}
+	}
+
+	p.mu.Lock()
+	p.queue = append(p.queue, metrics...)
+	if over := len(p.queue) - p.bufferSize; over > 0 {
+		p.queue = p.queue[over:]
+		p.dropped += over
+	}
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return
+}
+
+// Close stops sending metrics.  Metrics still buffered are lost.
+func (p *Publisher) Close() {
+	close(p.stop)
+	<-p.done
+}
+
+// run sends queued metrics until the publisher is closed, reconnecting to
+// carbon with backoff whenever it cannot be reached.
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	delay := minBackoff
+	fail := func(format string, args ...interface{}) bool {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+		log.Printf("graphite: "+format+", retrying in %s", append(args, delay)...)
+		select {
+		case <-time.After(delay):
+		case <-p.stop:
+			return false
+		}
+		if delay *= 2; delay > maxBackoff {
+			delay = maxBackoff
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-p.wake:
+		case <-p.stop:
+			return
+		}
+
+		for {
+			batch, dropped := p.peek()
+			if len(batch) == 0 {
+				break
+			}
+
+			if conn == nil {
+				var err error
+				conn, err = net.DialTimeout("tcp", p.addr, writeTimeout)
+				if err != nil {
+					conn = nil
+					if !fail("connecting to %s: %s", p.addr, err) {
+						return
+					}
+					continue
+				}
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := p.write(conn, batch); err != nil {
+				if !fail("writing to %s: %s", p.addr, err) {
+					return
+				}
+				continue
+			}
+
+			p.discard(len(batch), dropped)
+			delay = minBackoff
+		}
+	}
+}
+
+// peek returns the oldest queued metrics, and the count of metrics dropped
+// so far.
+func (p *Publisher) peek() ([]metric, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.queue)
+	if n > maxBatch {
+		n = maxBatch
+	}
+	return append([]metric(nil), p.queue[:n]...), p.dropped
+}
+
+// discard removes n sent metrics from the front of the queue, less any
+// that were dropped since they were peeked.
+func (p *Publisher) discard(n, dropped int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n -= p.dropped - dropped
+	if n > len(p.queue) {
+		n = len(p.queue)
+	}
+	if n > 0 {
+		p.queue = p.queue[n:]
+	}
+}
+
+func (p *Publisher) write(w io.Writer, batch []metric) error {
+	var buf bytes.Buffer
+	switch p.protocol {
+	case Pickle:
+		body := pickle(batch)
+		binary.Write(&buf, binary.BigEndian, uint32(len(body)))
+		buf.Write(body)
+	default:
+		for _, m := range batch {
+			fmt.Fprintf(&buf, "%s %s %d\n", m.path, strconv.FormatFloat(m.value, 'f', -1, 64), m.timestamp)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pickle encodes metrics as the list of (path, (timestamp, value)) tuples
+// carbon's pickle listener expects, using pickle protocol 2.
+func pickle(batch []metric) []byte {
+	var b bytes.Buffer
+	b.WriteString("\x80\x02") // PROTO 2
+	b.WriteString("](")       // EMPTY_LIST, MARK
+	for _, m := range batch {
+		b.WriteByte('X') // BINUNICODE
+		binary.Write(&b, binary.LittleEndian, uint32(len(m.path)))
+		b.WriteString(m.path)
+
+		if m.timestamp >= math.MinInt32 && m.timestamp <= math.MaxInt32 {
+			b.WriteByte('J') // BININT
+			binary.Write(&b, binary.LittleEndian, int32(m.timestamp))
+		} else {
+			b.WriteString("\x8a\x08") // LONG1, 8 bytes
+			binary.Write(&b, binary.LittleEndian, m.timestamp)
+		}
+
+		b.WriteByte('G') // BINFLOAT
+		binary.Write(&b, binary.BigEndian, m.value)
+
+		b.WriteString("\x86\x86") // TUPLE2, TUPLE2
+	}
+	b.WriteString("e.") // APPENDS, STOP
+	return b.Bytes()
+}
+
+// Sanitize makes s safe to use as a single component of a metric path,
+// replacing anything but letters, digits, '-' and '_' with '_'.  Dots
+// would otherwise split the path, and spaces break the plaintext protocol.
+func Sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+	if s == "" {
+		return "_"
+	}
+	return s
+}
@@ -0,0 +1,194 @@
+package graphitepublisher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+func init() {
+	minBackoff = 10 * time.Millisecond
+}
+
+func measurement(name string, err error) sensor.Measurement {
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	return sensor.Measurement{
+		Target: sampler.Target{Name: name},
+		Sample: sampler.Sample{
+			TimeStart: t1,
+			TimeEnd:   t1.Add(250 * time.Millisecond),
+		},
+		IsOK:  err == nil,
+		Error: err,
+	}
+}
+
+func accept(t *testing.T, l net.Listener) net.Conn {
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readLines(t *testing.T, r *bufio.Reader, n int) []string {
+	var lines []string
+	for len(lines) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line[:len(line)-1])
+	}
+	return lines
+}
+
+func TestPublishPlaintext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	p, err := New(l.Addr().String(), "canary", Plaintext, DefaultBufferSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Publish(measurement("www.canary.io", &sampler.StatusCodeError{StatusCode: 500}))
+
+	conn := accept(t, l)
+	defer conn.Close()
+
+	expected := []string{
+		"canary.www_canary_io.latency 250 1419724800",
+		"canary.www_canary_io.up 0 1419724800",
+		"canary.www_canary_io.errors 1 1419724800",
+		"canary.www_canary_io.errors.http 1 1419724800",
+	}
+	lines := readLines(t, bufio.NewReader(conn), len(expected))
+	for i, e := range expected {
+		if lines[i] != e {
+			t.Errorf("expected line %d to be %q, got %q", i, e, lines[i])
+		}
+	}
+}
+
+func TestPublishPickle(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	p, err := New(l.Addr().String(), "canary", Pickle, DefaultBufferSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	m := measurement("www", nil)
+	m.Sample.TimeStart = m.Sample.TimeEnd.Add(-500 * time.Millisecond)
+	p.Publish(m)
+
+	conn := accept(t, l)
+	defer conn.Close()
+
+	var length uint32
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected bytes.Buffer
+	expected.WriteString("\x80\x02](")
+	for _, m := range []struct {
+		path  string
+		value []byte
+	}{
+		{"canary.www.latency", []byte{0x40, 0x7f, 0x40, 0, 0, 0, 0, 0}}, // 500.0
+		{"canary.www.up", []byte{0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},         // 1.0
+	} {
+		expected.WriteByte('X')
+		expected.Write([]byte{byte(len(m.path)), 0, 0, 0})
+		expected.WriteString(m.path)
+		expected.Write([]byte{'J', 0x00, 0x48, 0x9f, 0x54}) // 1419724800
+		expected.WriteByte('G')
+		expected.Write(m.value)
+		expected.WriteString("\x86\x86")
+	}
+	expected.WriteString("e.")
+
+	if !bytes.Equal(body, expected.Bytes()) {
+		t.Fatalf("expected pickle:\n%q\nbut got:\n%q", expected.Bytes(), body)
+	}
+}
+
+func TestPublishBuffersWhileUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	// without a start time, only up is sent for each measurement
+	p, err := New(addr, "canary", Plaintext, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for _, name := range []string{"one", "two", "three"} {
+		m := measurement(name, nil)
+		m.Sample.TimeStart = time.Time{}
+		p.Publish(m)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn := accept(t, l)
+	defer conn.Close()
+
+	// the oldest metric was dropped once the buffer filled up
+	expected := []string{
+		"canary.two.up 1 1419724800",
+		"canary.three.up 1 1419724800",
+	}
+	lines := readLines(t, bufio.NewReader(conn), len(expected))
+	for i, e := range expected {
+		if lines[i] != e {
+			t.Errorf("expected line %d to be %q, got %q", i, e, lines[i])
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	for in, expected := range map[string]string{
+		"www.canary.io":   "www_canary_io",
+		"api v1 (prod)":   "api_v1__prod_",
+		"already-safe_01": "already-safe_01",
+		"":                "_",
+	} {
+		if out := Sanitize(in); out != expected {
+			t.Errorf("expected %q to be sanitized to %q, got %q", in, expected, out)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package influxpublisher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+const (
+	// DefaultMeasurement names the points if INFLUX_MEASUREMENT is unset.
+	DefaultMeasurement = "canary"
+
+	// DefaultBatchSize is how many points are written at once if
+	// INFLUX_BATCH_SIZE is unset.
+	DefaultBatchSize = 1000
+
+	// DefaultBufferSize is how many points are kept while writes fail.
+	DefaultBufferSize = 10000
+
+	// FlushInterval is how long points may wait for a batch to fill.
+	FlushInterval = time.Second
+
+	// MaxRetries is how many times a failed write is retried.
+	MaxRetries = 3
+
+	// udpPayload bounds the size of UDP packets.
+	udpPayload = 1400
+
+	writeTimeout = 10 * time.Second
+)
+
+// retryDelay is the delay before the first retry, doubling for each retry.
+var retryDelay = time.Second
+
+// Publisher implements the canary.Publisher interface, and writes each
+// measurement as an InfluxDB line protocol point.  Points are written in
+// batches, over HTTP or UDP.
+type Publisher struct {
+	Measurement string
+	BatchSize   int
+	BufferSize  int
+
+	send func(lines [][]byte) error
+
+	mu      sync.Mutex
+	pending [][]byte
+	sendMu  sync.Mutex // serializes writes, so that points stay in order
+
+	wake chan struct{}
+	stop chan struct{}
+}
+
+// HTTPConfig configures writes to the HTTP /write endpoint.
+type HTTPConfig struct {
+	URL             string // base URL of the server, e.g. http://localhost:8086
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+	Token           string // InfluxDB 2 API token, sent instead of a username and password
+}
+
+// NewHTTP returns a pointer to a new Publisher writing to the HTTP /write
+// endpoint.  Failed writes are retried up to MaxRetries times, with backoff.
+func NewHTTP(config HTTPConfig, measurement string) (*Publisher, error) {
+	u, err := url.Parse(strings.TrimSuffix(config.URL, "/") + "/write")
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{"db": {config.Database}, "precision": {"ns"}}
+	if config.RetentionPolicy != "" {
+		params.Set("rp", config.RetentionPolicy)
+	}
+	u.RawQuery = params.Encode()
+	endpoint := u.String()
+
+	client := &http.Client{Timeout: writeTimeout}
+	send := func(lines [][]byte) error {
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(bytes.Join(lines, []byte("\n"))))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if config.Token != "" {
+			req.Header.Set("Authorization", "Token "+config.Token)
+		} else if config.Username != "" {
+			req.SetBasicAuth(config.Username, config.Password)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			err := fmt.Errorf("received HTTP status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+			// the server rejected the points, so retrying will not help
+			if resp.StatusCode/100 == 4 {
+				return permanentError{err}
+			}
+			return err
+		}
+		return nil
+	}
+
+	return newPublisher(measurement, send), nil
+}
+
+// NewUDP returns a pointer to a new Publisher writing to a UDP listener.
+// Points are packed into packets of up to 1400 bytes, and are not retried.
+func NewUDP(addr, measurement string) (*Publisher, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	send := func(lines [][]byte) error {
+		var packet []byte
+		for _, line := range lines {
+			if len(packet) > 0 && len(packet)+1+len(line) > udpPayload {
+				if _, err := conn.Write(packet); err != nil {
+					return permanentError{err}
+				}
+				packet = packet[:0]
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+		if len(packet) > 0 {
+			if _, err := conn.Write(packet); err != nil {
+				return permanentError{err}
+			}
+		}
+		return nil
+	}
+
+	return newPublisher(measurement, send), nil
+}
+
+// NewFromEnv is a convenience func that wraps NewHTTP or NewUDP, and
+// populates their arguments via environment variables.
+func NewFromEnv() (*Publisher, error) {
+	measurement := os.Getenv("INFLUX_MEASUREMENT")
+	if measurement == "" {
+		measurement = DefaultMeasurement
+	}
+
+	var p *Publisher
+	var err error
+	if addr := os.Getenv("INFLUX_UDP_ADDR"); addr != "" {
+		p, err = NewUDP(addr, measurement)
+	} else {
+		config := HTTPConfig{
+			URL:             os.Getenv("INFLUX_URL"),
+			Database:        os.Getenv("INFLUX_DB"),
+			RetentionPolicy: os.Getenv("INFLUX_RP"),
+			Username:        os.Getenv("INFLUX_USER"),
+			Password:        os.Getenv("INFLUX_PASSWORD"),
+			Token:           os.Getenv("INFLUX_TOKEN"),
+		}
+		if config.URL == "" {
+			return nil, fmt.Errorf("INFLUX_URL or INFLUX_UDP_ADDR must be set in ENV")
+		}
+		if config.Database == "" {
+			return nil, fmt.Errorf("INFLUX_DB not set in ENV")
+		}
+		p, err = NewHTTP(config, measurement)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s := os.Getenv("INFLUX_BATCH_SIZE"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("INFLUX_BATCH_SIZE is not a valid positive integer")
+		}
+		p.mu.Lock()
+		p.BatchSize = size
+		p.mu.Unlock()
+	}
+	return p, nil
+}
+
+func newPublisher(measurement string, send func([][]byte) error) *Publisher {
+	p := &Publisher{
+		Measurement: measurement,
+		BatchSize:   DefaultBatchSize,
+		BufferSize:  DefaultBufferSize,
+		send:        send,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+	go p.flushPeriodically()
+	return p
+}
+
+// permanentError is a write error that retrying will not fix.
+type permanentError struct {
+	error
+}
+
+// Publish takes a canary.Measurement and queues it as a point, writing a
+// batch once BatchSize points are queued.
+func (p *Publisher) Publish(m sensor.Measurement) (err error) {
+	p.mu.Lock()
+	p.pending = append(p.pending, Point(p.Measurement, m))
+	if over := len(p.pending) - p.BufferSize; over > 0 {
+		p.pending = p.pending[over:]
+	}
+	full := len(p.pending) >= p.BatchSize
+	p.mu.Unlock()
+
+	if full {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+	return
+}
+
+// Flush writes every queued point, in batches of up to BatchSize.
+func (p *Publisher) Flush() error {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+
+	for {
+		p.mu.Lock()
+		n := len(p.pending)
+		if n > p.BatchSize {
+			n = p.BatchSize
+		}
+		batch := p.pending[:n:n]
+		p.pending = p.pending[n:]
+		p.mu.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := p.write(batch); err != nil {
+			return err
+		}
+	}
+}
+
+// Close writes every queued point and stops the publisher.
+func (p *Publisher) Close() error {
+	close(p.stop)
+	return p.Flush()
+}
+
+// write sends a batch, retrying failures with backoff.
+func (p *Publisher) write(batch [][]byte) (err error) {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err = p.send(batch)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(permanentError); ok || attempt == MaxRetries {
+			return fmt.Errorf("influx: dropping %d points: %s", len(batch), err)
+		}
+
+		log.Printf("influx: write failed, retrying in %s: %s", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-p.stop:
+		}
+		delay *= 2
+	}
+}
+
+func (p *Publisher) flushPeriodically() {
+	t := time.NewTicker(FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-p.wake:
+		case <-p.stop:
+			return
+		}
+		if err := p.Flush(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// Point renders a measurement as a line protocol point.  The target name,
+// URL host, remote IP, tags and attributes are tags; the phase latencies in
+// milliseconds, status code, ok and state count are fields; and the start
+// of the sample is the timestamp.
+func Point(measurement string, m sensor.Measurement) []byte {
+	tags := map[string]string{}
+	for k, v := range m.Target.Attributes {
+		tags[k] = v
+	}
+	if len(m.Target.Tags) > 0 {
+		tags["tags"] = strings.Join(m.Target.Tags, ",")
+	}
+	tags["name"] = m.Target.Name
+	if m.Target.URL.URL != nil {
+		tags["host"] = m.Target.URL.Host
+	}
+	if m.Sample.RemoteAddr != nil {
+		tags["remote_ip"] = m.Sample.RemoteAddr.String()
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if k != "" && v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.WriteString(escape(measurement, ", "))
+	for _, k := range keys {
+		b.WriteString("," + escape(k, ",= ") + "=" + escape(tags[k], ",= "))
+	}
+
+	s := m.Sample
+	fields := []string{}
+	between := func(field string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			ms := to.Sub(from).Seconds() * 1000
+			fields = append(fields, field+"="+strconv.FormatFloat(ms, 'f', -1, 64))
+		}
+	}
+	between("resolve_ms", s.TimeStart, s.TimeToResolveIP)
+	between("connect_ms", s.TimeToResolveIP, s.TimeToConnect)
+	between("first_byte_ms", s.TimeToConnect, s.TimeToFirstByte)
+	between("transfer_ms", s.TimeToFirstByte, s.TimeEnd)
+	between("total_ms", s.TimeStart, s.TimeEnd)
+	if s.StatusCode != 0 {
+		fields = append(fields, fmt.Sprintf("status_code=%di", s.StatusCode))
+	}
+	// ok is the confirmed state of the target, sample_ok the result of this
+	// sample alone
+	fields = append(fields, fmt.Sprintf("ok=%t", m.IsOK), fmt.Sprintf("state_count=%di", m.StateCount),
+		fmt.Sprintf("sample_ok=%t", m.SampleOK), fmt.Sprintf("retried=%t", m.Retried), fmt.Sprintf("flapping=%t", m.Flapping))
+	if m.InMaintenance {
+		fields = append(fields, "in_maintenance=true")
+	}
+	if m.Error != nil {
+		class := "sampler"
+		switch m.Error.(type) {
+		case sampler.StatusCodeError, *sampler.StatusCodeError:
+			class = "http"
+		}
+		fields = append(fields, `error_class="`+class+`"`, `error="`+escape(m.Error.Error(), `"\`)+`"`)
+	}
+	b.WriteString(" " + strings.Join(fields, ","))
+
+	timestamp := s.TimeStart
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	b.WriteString(" " + strconv.FormatInt(timestamp.UnixNano(), 10))
+
+	return b.Bytes()
+}
+
+// escape backslash-escapes the given characters in s.  Newlines cannot be
+// escaped in line protocol, so they are replaced with spaces first.
+func escape(s, chars string) string {
+	s = strings.Replace(s, "\n", " ", -1)
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package influxpublisher
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+func init() {
+	retryDelay = time.Millisecond
+}
+
+func measurement(name string, err error) sensor.Measurement {
+	u, _ := sampler.NewJsonURL("https://www.canary.io/status")
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	return sensor.Measurement{
+		Target: sampler.Target{
+			URL:        *u,
+			Name:       name,
+			Tags:       []string{"web", "prod"},
+			Attributes: map[string]string{"team": "site ops"},
+		},
+		Sample: sampler.Sample{
+			StatusCode:      503,
+			TimeStart:       t1,
+			TimeToResolveIP: t1.Add(10 * time.Millisecond),
+			TimeToConnect:   t1.Add(30 * time.Millisecond),
+			TimeToFirstByte: t1.Add(130 * time.Millisecond),
+			TimeEnd:         t1.Add(150 * time.Millisecond),
+			RemoteAddr:      net.ParseIP("192.0.2.1"),
+		},
+		IsOK:       err == nil,
+		SampleOK:   err == nil,
+		StateCount: 2,
+		Error:      err,
+	}
+}
+
+func TestPoint(t *testing.T) {
+	point := Point("canary", measurement("www", &sampler.StatusCodeError{StatusCode: 503}))
+
+	expected := `canary,host=www.canary.io,name=www,remote_ip=192.0.2.1,tags=web\,prod,team=site\ ops ` +
+		`resolve_ms=10,connect_ms=20,first_byte_ms=100,transfer_ms=20,total_ms=150,` +
+		`status_code=503i,ok=false,state_count=2i,sample_ok=false,retried=false,flapping=false,` +
+		`error_class="http",error="recieved HTTP status 503" ` +
+		`1419724800000000000`
+	if string(point) != expected {
+		t.Fatalf("expected point:\n%s\nbut got:\n%s", expected, point)
+	}
+}
+
+func TestPublishHTTP(t *testing.T) {
+	var bodies []string
+	var query, auth string
+	attempts := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		query, auth = r.URL.RawQuery, r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	p, err := NewHTTP(HTTPConfig{URL: ts.URL, Database: "canary", Token: "secret"}, "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Publish(measurement("one", nil))
+	p.Publish(measurement("two", nil))
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 2 {
+		t.Fatalf("expected the failed write to be retried once, got %d attempts", attempts)
+	}
+	if query != "db=canary&precision=ns" || auth != "Token secret" {
+		t.Fatalf("expected the database and token to be sent, got %s and %s", query, auth)
+	}
+	if len(bodies) != 1 || strings.Count(bodies[0], "\n") != 1 || !strings.Contains(bodies[0], "name=two") {
+		t.Fatalf("expected both points in a single batch, got %q", bodies)
+	}
+}
+
+func TestPublishHTTPDropsRejectedPoints(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "unable to parse", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	p, err := NewHTTP(HTTPConfig{URL: ts.URL, Database: "canary"}, "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Publish(measurement("one", nil))
+	if err := p.Flush(); err == nil || !strings.Contains(err.Error(), "unable to parse") {
+		t.Fatalf("expected the rejection to be reported, got %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected rejected points not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestPublishUDP(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	p, err := NewUDP(l.LocalAddr().String(), "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for i := 0; i < 10; i++ {
+		p.Publish(measurement("www", nil))
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	points := 0
+	buf := make([]byte, 65536)
+	for points < 10 {
+		l.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := l.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > udpPayload {
+			t.Fatalf("expected packets of at most %d bytes, got %d", udpPayload, n)
+		}
+		points += strings.Count(string(buf[:n]), "\n") + 1
+	}
+	if points != 10 {
+		t.Fatalf("expected 10 points, got %d", points)
+	}
+}
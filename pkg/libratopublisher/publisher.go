@@ -65,7 +65,7 @@ func mapMeasurement(m sensor.Measurement) map[string]float64 {
 	// latency
 	latency := m.Sample.TimeEnd.Sub(m.Sample.TimeStart).Seconds() * 1000
 	metrics["canary."+m.Target.Name+".latency"] = latency
-	if m.Error != nil {
+	if m.Error != nil && !m.InMaintenance {
 		// increment a general error metric
 		metrics["canary."+m.Target.Name+".errors"] = 1
 
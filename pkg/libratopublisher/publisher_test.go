@@ -136,3 +136,28 @@ func TestBadTransportMeasurement(t *testing.T) {
 		)
 	}
 }
+
+func TestMaintenanceMeasurement(t *testing.T) {
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	t2, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:07Z")
+
+	m := sensor.Measurement{
+		Target: sampler.Target{
+			Name: "test",
+		},
+		Sample: sampler.Sample{
+			TimeStart:  t1,
+			TimeEnd:    t2,
+			StatusCode: 502,
+		},
+		Error: sampler.StatusCodeError{
+			StatusCode: 502,
+		},
+		InMaintenance: true,
+	}
+	res := mapMeasurement(m)
+
+	if len(res) != 1 {
+		t.Fatalf("expected only the latency of a measurement in maintenance, found %v", res)
+	}
+}
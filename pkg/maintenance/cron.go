@@ -0,0 +1,157 @@
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cron is a parsed cron expression: a set of allowed values for each of
+// its five fields.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// parseCron parses an expression of five fields, minute, hour, day of
+// month, month and day of week, in the syntax of crontab(5): each field is
+// *, a value, a range a-b, or a comma-separated list of them, and may have
+// a /step.  Days of week are 0 to 7, both 0 and 7 being Sunday.
+func parseCron(expr string) (*cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	c := &cron{}
+	specs := []struct {
+		name     string
+		bits     *uint64
+		min, max int
+	}{
+		{"minute", &c.minute, 0, 59},
+		{"hour", &c.hour, 0, 23},
+		{"day of month", &c.dom, 1, 31},
+		{"month", &c.month, 1, 12},
+		{"day of week", &c.dow, 0, 7},
+	}
+	for i, spec := range specs {
+		bits, err := parseField(fields[i], spec.min, spec.max)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", spec.name, err)
+		}
+		*spec.bits = bits
+	}
+
+	// Sunday is both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseField returns the set of values of a field, as bits.
+func parseField(field string, min, max int) (bits uint64, err error) {
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			rng = item[:i]
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			parts := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(parts[0]); err == nil {
+				hi, err = strconv.Atoi(parts[1])
+			}
+			if err != nil {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			if lo, err = strconv.Atoi(rng); err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			hi = lo
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", rng, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matches reports whether the expression matches the minute of t.
+func (c *cron) matches(t time.Time) bool {
+	return c.minute&(1<<uint(t.Minute())) != 0 && c.hour&(1<<uint(t.Hour())) != 0 && c.matchesDay(t)
+}
+
+// matchesDay reports whether the expression matches the day of t.  As in
+// cron, if both days of month and of week are restricted, either matches.
+func (c *cron) matchesDay(t time.Time) bool {
+	if c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// prev returns the last minute at or before t that the expression matches,
+// in the location of t.  It gives up once it reaches the days before since.
+func (c *cron) prev(t, since time.Time) (time.Time, bool) {
+	// days are stepped over in UTC, where they all last 24 hours
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	hour, minute := t.Hour(), t.Minute()
+	for {
+		if c.matchesDay(day) {
+			if h, m, ok := c.latest(hour, minute); ok {
+				return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, t.Location()), true
+			}
+		}
+
+		day = day.AddDate(0, 0, -1)
+		hour, minute = 23, 59
+		if time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, t.Location()).Before(since) {
+			return time.Time{}, false
+		}
+	}
+}
+
+// latest returns the last hour and minute of a day, up to hour:minute,
+// that the expression matches.
+func (c *cron) latest(hour, minute int) (int, int, bool) {
+	for h := hour; h >= 0; h-- {
+		if c.hour&(1<<uint(h)) == 0 {
+			continue
+		}
+		last := 59
+		if h == hour {
+			last = minute
+		}
+		for m := last; m >= 0; m-- {
+			if c.minute&(1<<uint(m)) != 0 {
+				return h, m, true
+			}
+		}
+	}
+	return 0, 0, false
+}
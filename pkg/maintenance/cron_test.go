@@ -0,0 +1,120 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{
+		"* * * * *",
+		"0 2 * * 0",
+		"*/15 9-17 * * 1-5",
+		"0,30 0 1,15 * 7",
+		"5/20 * * 1-12/3 *",
+	} {
+		if _, err := parseCron(expr); err != nil {
+			t.Errorf("expected %q to parse, got %s", expr, err)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("expected %q not to parse", expr)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04 Mon", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		expr    string
+		time    string
+		matches bool
+	}{
+		{"0 2 * * 0", "2026-10-18 02:00 Sun", true},
+		{"0 2 * * 7", "2026-10-18 02:00 Sun", true},
+		{"0 2 * * 0", "2026-10-19 02:00 Mon", false},
+		{"0 2 * * 0", "2026-10-18 02:01 Sun", false},
+		{"*/15 9-17 * * 1-5", "2026-10-19 17:45 Mon", true},
+		{"*/15 9-17 * * 1-5", "2026-10-19 18:00 Mon", false},
+		{"*/15 9-17 * * 1-5", "2026-10-19 09:10 Mon", false},
+		{"5/20 * * * *", "2026-10-19 09:45 Mon", true},
+		{"5/20 * * * *", "2026-10-19 09:00 Mon", false},
+		// either day matches if both are restricted
+		{"0 0 1 * 1", "2026-10-19 00:00 Mon", true},
+		{"0 0 1 * 1", "2026-10-01 00:00 Thu", true},
+		{"0 0 1 * 1", "2026-10-02 00:00 Fri", false},
+		// both must match if one is *
+		{"0 0 * 10 1", "2026-10-20 00:00 Tue", false},
+		{"0 0 */2 * *", "2026-10-19 00:00 Mon", true},
+		{"0 0 */2 * *", "2026-10-20 00:00 Tue", false},
+	}
+	for _, test := range tests {
+		c, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.matches(at(test.time)); got != test.matches {
+			t.Errorf("expected %q matching %s to be %t, got %t", test.expr, test.time, test.matches, got)
+		}
+	}
+}
+
+func TestCronPrev(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		expr  string
+		t     string
+		since string
+		want  string // empty if there is no match since since
+	}{
+		{"0 2 * * 0", "2026-10-18 02:00", "2026-10-17 00:00", "2026-10-18 02:00"},
+		{"0 2 * * 0", "2026-10-18 01:59", "2026-10-10 00:00", "2026-10-11 02:00"},
+		{"0 2 * * 0", "2026-10-18 01:59", "2026-10-12 00:00", ""},
+		{"*/15 9-17 * * 1-5", "2026-10-19 12:14", "2026-10-19 00:00", "2026-10-19 12:00"},
+		{"*/15 9-17 * * 1-5", "2026-10-19 08:59", "2026-10-16 00:00", "2026-10-16 17:45"},
+		{"30 23 31 * *", "2026-10-01 00:00", "2026-08-01 00:00", "2026-08-31 23:30"},
+	}
+	for _, test := range tests {
+		c, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := c.prev(at(test.t), at(test.since))
+		if test.want == "" {
+			if ok {
+				t.Errorf("expected no match of %q before %s since %s, got %s", test.expr, test.t, test.since, got)
+			}
+			continue
+		}
+		if !ok || !got.Equal(at(test.want)) {
+			t.Errorf("expected the last match of %q before %s to be %s, got %s", test.expr, test.t, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package maintenance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+// Window is a period of planned maintenance, declared in the manifest:
+// either an absolute range from Start to End, or every time its cron
+// Schedule matches, for Duration seconds.
+//
+// A window applies to the targets it names and to those with one of its
+// tags, or to every target if it has neither.
+type Window struct {
+	Name    string   `json:"name,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+
+	Schedule string `json:"schedule,omitempty"` // minute hour day-of-month month day-of-week
+	Duration int    `json:"duration,omitempty"` // in seconds
+	Timezone string `json:"timezone,omitempty"` // of Schedule, defaults to UTC
+}
+
+// Validate checks that the window is either a range or a schedule.
+func (w Window) Validate() error {
+	_, err := compile(w)
+	return err
+}
+
+// Silence is an ad-hoc maintenance window, added through the admin API.
+type Silence struct {
+	ID      string    `json:"id"`
+	Targets []string  `json:"targets,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+// window is a Window ready to be evaluated.
+type window struct {
+	Window
+	cron     *cron
+	location *time.Location
+}
+
+func compile(w Window) (window, error) {
+	c := window{Window: w}
+	if w.Schedule == "" {
+		if w.Start == nil || w.End == nil {
+			return c, fmt.Errorf("either start and end, or schedule, must be set")
+		}
+		if !w.End.After(*w.Start) {
+			return c, fmt.Errorf("end must be after start")
+		}
+		if w.Duration != 0 || w.Timezone != "" {
+			return c, fmt.Errorf("duration and timezone only apply to a schedule")
+		}
+		return c, nil
+	}
+
+	if w.Start != nil || w.End != nil {
+		return c, fmt.Errorf("start and end cannot be combined with schedule")
+	}
+	if w.Duration <= 0 {
+		return c, fmt.Errorf("a schedule needs a positive duration")
+	}
+	var err error
+	if c.cron, err = parseCron(w.Schedule); err != nil {
+		return c, fmt.Errorf("schedule %q: %s", w.Schedule, err)
+	}
+	c.location = time.UTC
+	if w.Timezone != "" {
+		if c.location, err = time.LoadLocation(w.Timezone); err != nil {
+			return c, fmt.Errorf("timezone: %s", err)
+		}
+	}
+	return c, nil
+}
+
+// active reports whether the window is open at t.
+func (w window) active(t time.Time) bool {
+	if w.cron == nil {
+		return !t.Before(*w.Start) && t.Before(*w.End)
+	}
+
+	// the window is open if it last started within Duration before t
+	d := time.Duration(w.Duration) * time.Second
+	start, ok := w.cron.prev(t.In(w.location), t.Add(-d))
+	return ok && t.Sub(start) < d
+}
+
+// Calendar holds the maintenance windows of the manifest and the silences
+// added through the admin API, and tells whether a target is under
+// maintenance.  The zero value is an empty calendar ready to use, and it is
+// safe for concurrent use.
+type Calendar struct {
+	mu       sync.Mutex
+	windows  []window
+	silences []Silence
+}
+
+// SetWindows replaces the maintenance windows.  If one of them is invalid,
+// the windows are left unchanged.
+func (c *Calendar) SetWindows(windows []Window) error {
+	compiled := make([]window, len(windows))
+	for i, w := range windows {
+		var err error
+		if compiled[i], err = compile(w); err != nil {
+			return fmt.Errorf("maintenance[%d]: %s", i, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.windows = compiled
+	return nil
+}
+
+// AddSilence adds a silence, and returns it with its ID.  A silence without
+// a start starts now.
+func (c *Calendar) AddSilence(s Silence) (Silence, error) {
+	if s.Start.IsZero() {
+		s.Start = time.Now()
+	}
+	if !s.End.After(s.Start) {
+		return s, fmt.Errorf("end must be after start")
+	}
+	if !s.End.After(time.Now()) {
+		return s, fmt.Errorf("end must be in the future")
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return s, err
+	}
+	s.ID = hex.EncodeToString(id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.silences = append(c.silences, s)
+	return s, nil
+}
+
+// RemoveSilence removes the silence with the given ID, and reports whether
+// it was found.
+func (c *Calendar) RemoveSilence(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, s := range c.silences {
+		if s.ID == id {
+			c.silences = append(c.silences[:i], c.silences[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Silences returns the silences that have not ended, by start.
+func (c *Calendar) Silences() []Silence {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(time.Now())
+
+	silences := append([]Silence(nil), c.silences...)
+	sort.SliceStable(silences, func(i, j int) bool { return silences[i].Start.Before(silences[j].Start) })
+	return silences
+}
+
+// InMaintenance reports whether a window or silence covering the target is
+// open at t.
+func (c *Calendar) InMaintenance(target sampler.Target, t time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(time.Now())
+
+	for _, s := range c.silences {
+		if covers(s.Targets, s.Tags, target) && !t.Before(s.Start) && t.Before(s.End) {
+			return true
+		}
+	}
+	for _, w := range c.windows {
+		if covers(w.Targets, w.Tags, target) && w.active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// expire forgets the silences that ended before now.  c.mu must be held.
+func (c *Calendar) expire(now time.Time) {
+	kept := c.silences[:0]
+	for _, s := range c.silences {
+		if now.Before(s.End) {
+			kept = append(kept, s)
+		}
+	}
+	c.silences = kept
+}
+
+// covers reports whether a window for the names and tags applies to the
+// target.
+func covers(names, tags []string, target sampler.Target) bool {
+	if len(names) == 0 && len(tags) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if name == target.Name {
+			return true
+		}
+	}
+	for _, tag := range tags {
+		for _, t := range target.Tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
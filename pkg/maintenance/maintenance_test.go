@@ -0,0 +1,152 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+func parseTime(t *testing.T, s string) time.Time {
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func windows(t *testing.T, doc string) []Window {
+	var ws []Window
+	if err := json.Unmarshal([]byte(doc), &ws); err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestValidate(t *testing.T) {
+	ws := windows(t, `[
+		{"start": "2026-10-19T10:00:00Z", "end": "2026-10-19T12:00:00Z"},
+		{"schedule": "0 2 * * 0", "duration": 3600, "timezone": "UTC"},
+		{"start": "2026-10-19T10:00:00Z"},
+		{"start": "2026-10-19T12:00:00Z", "end": "2026-10-19T10:00:00Z"},
+		{"start": "2026-10-19T10:00:00Z", "end": "2026-10-19T12:00:00Z", "duration": 60},
+		{"schedule": "0 2 * * 0"},
+		{"schedule": "0 2 * *", "duration": 3600},
+		{"schedule": "0 2 * * 0", "duration": 3600, "end": "2026-10-19T12:00:00Z"},
+		{"schedule": "0 2 * * 0", "duration": 3600, "timezone": "Nowhere/Special"},
+		{}
+	]`)
+
+	for i, w := range ws {
+		err := w.Validate()
+		if valid := i < 2; valid != (err == nil) {
+			t.Errorf("expected window %d to be valid: %t, got %v", i, valid, err)
+		}
+	}
+}
+
+func TestInMaintenance(t *testing.T) {
+	var c Calendar
+	err := c.SetWindows(windows(t, `[
+		{"name": "migration", "targets": ["db"], "start": "2026-10-19T10:00:00Z", "end": "2026-10-19T12:00:00Z"},
+		{"name": "deploys", "tags": ["api"], "schedule": "30 2 * * 0", "duration": 5400}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sampler.Target{Name: "db", Tags: []string{"storage"}}
+	api := sampler.Target{Name: "api", Tags: []string{"prod", "api"}}
+	www := sampler.Target{Name: "www", Tags: []string{"prod"}}
+
+	tests := []struct {
+		target sampler.Target
+		time   string
+		want   bool
+	}{
+		{db, "2026-10-19T09:59:59Z", false},
+		{db, "2026-10-19T10:00:00Z", true},
+		{db, "2026-10-19T11:59:59Z", true},
+		{db, "2026-10-19T12:00:00Z", false},
+		{www, "2026-10-19T11:00:00Z", false},
+		// Sunday 02:30 to 04:00
+		{api, "2026-10-18T02:29:59Z", false},
+		{api, "2026-10-18T02:30:00Z", true},
+		{api, "2026-10-18T03:59:59Z", true},
+		{api, "2026-10-18T04:00:00Z", false},
+		{api, "2026-10-19T03:00:00Z", false},
+		{www, "2026-10-18T03:00:00Z", false},
+	}
+	for _, test := range tests {
+		if got := c.InMaintenance(test.target, parseTime(t, test.time)); got != test.want {
+			t.Errorf("expected %s in maintenance at %s: %t, got %t", test.target.Name, test.time, test.want, got)
+		}
+	}
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	var c Calendar
+	err := c.SetWindows(windows(t, `[{"schedule": "0 2 * * *", "duration": 600, "timezone": "Local"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := time.Date(2026, 10, 19, 2, 5, 0, 0, time.Local)
+	if !c.InMaintenance(sampler.Target{Name: "www"}, local) {
+		t.Errorf("expected the schedule to be evaluated in local time")
+	}
+}
+
+func TestSetWindowsKeepsWindowsOnError(t *testing.T) {
+	var c Calendar
+	c.SetWindows(windows(t, `[{"start": "2026-10-19T10:00:00Z", "end": "2026-10-19T12:00:00Z"}]`))
+
+	if err := c.SetWindows(windows(t, `[{"schedule": "bogus", "duration": 60}]`)); err == nil {
+		t.Fatal("expected an error")
+	}
+	if !c.InMaintenance(sampler.Target{Name: "www"}, parseTime(t, "2026-10-19T11:00:00Z")) {
+		t.Error("expected the previous windows to be kept")
+	}
+}
+
+func TestSilences(t *testing.T) {
+	var c Calendar
+	now := time.Now()
+
+	s, err := c.AddSilence(Silence{Tags: []string{"api"}, End: now.Add(time.Hour), Comment: "deploying"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID == "" || s.Start.IsZero() {
+		t.Errorf("expected an ID and a start, got %+v", s)
+	}
+
+	if _, err := c.AddSilence(Silence{End: now.Add(-time.Minute)}); err == nil {
+		t.Error("expected a silence ending in the past to be rejected")
+	}
+	later, err := c.AddSilence(Silence{Targets: []string{"www"}, Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := sampler.Target{Name: "api", Tags: []string{"api"}}
+	www := sampler.Target{Name: "www"}
+	if !c.InMaintenance(api, time.Now()) || c.InMaintenance(www, time.Now()) {
+		t.Error("expected only api to be silenced now")
+	}
+	if !c.InMaintenance(www, now.Add(90*time.Minute)) {
+		t.Error("expected www to be silenced later")
+	}
+
+	if silences := c.Silences(); len(silences) != 2 || silences[0].ID != s.ID || silences[1].ID != later.ID {
+		t.Errorf("expected both silences by start, got %+v", silences)
+	}
+
+	if !c.RemoveSilence(s.ID) || c.RemoveSilence(s.ID) {
+		t.Error("expected the silence to be removed once")
+	}
+	if c.InMaintenance(api, time.Now()) {
+		t.Error("expected api not to be silenced once the silence is removed")
+	}
+}
@@ -0,0 +1,328 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consulWait is how long a Consul blocking query waits for a change.
+const consulWait = 5 * time.Minute
+
+// ConsulSource discovers targets from the services registered in a Consul
+// catalog.
+//
+// Every instance of a matching service becomes one instance, with the
+// template variables service, id, node, address and port, and meta.<key>
+// for each entry of the service metadata.  The service tags are added to
+// the target tags.
+type ConsulSource struct {
+	// Address is the URL of the Consul HTTP API, defaulting to
+	// $CONSUL_HTTP_ADDR or http://127.0.0.1:8500.
+	Address    string
+	Token      string // defaults to $CONSUL_HTTP_TOKEN
+	Datacenter string
+
+	Service string // only this service, if set
+	Tag     string // only services, and instances, with this tag
+	Passing bool   // only instances passing their health checks
+	Watch   bool   // reload as soon as the catalog changes
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		ID      string
+		Service string
+		Tags    []string
+		Address string
+		Port    int
+		Meta    map[string]string
+	}
+}
+
+func (c *ConsulSource) field() string {
+	return "consul"
+}
+
+func (c *ConsulSource) variables() []string {
+	return []string{"service", "id", "node", "address", "port", "meta.*"}
+}
+
+func (c *ConsulSource) nameVariables() []string {
+	return []string{"service", "node", "port"}
+}
+
+func (c *ConsulSource) defaultURL() string {
+	return "http://{address}:{port}/"
+}
+
+func (c *ConsulSource) watchKey() string {
+	if !c.Watch {
+		return ""
+	}
+	b, _ := json.Marshal(c)
+	return "consul:" + string(b)
+}
+
+// discover returns an instance for every instance of each matching service,
+// ordered by service, node and port.
+func (c *ConsulSource) discover(vars map[string]string) ([]instance, error) {
+	services, _, err := c.services(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []instance
+	for _, service := range services {
+		var entries []consulServiceEntry
+		_, err := c.get("/v1/health/service/"+url.PathEscape(service), c.healthParams(), 0, &entries)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].Node.Node != entries[j].Node.Node {
+				return entries[i].Node.Node < entries[j].Node.Node
+			}
+			return entries[i].Service.Port < entries[j].Service.Port
+		})
+
+		for _, e := range entries {
+			address := e.Service.Address
+			if address == "" {
+				address = e.Node.Address
+			}
+
+			v := map[string]string{
+				"service": e.Service.Service,
+				"id":      e.Service.ID,
+				"node":    e.Node.Node,
+				"address": address,
+				"port":    strconv.Itoa(e.Service.Port),
+			}
+			for k, m := range e.Service.Meta {
+				v["meta."+k] = m
+			}
+
+			instances = append(instances, instance{
+				vars:     v,
+				settings: TargetDefaults{Tags: e.Service.Tags},
+			})
+		}
+	}
+	return instances, nil
+}
+
+// services returns the sorted names of the matching services.  index is
+// used to make a blocking query; the index of the result is returned.
+func (c *ConsulSource) services(index uint64) ([]string, uint64, error) {
+	if c.Service != "" {
+		return []string{c.Service}, 0, nil
+	}
+
+	var catalog map[string][]string
+	index, err := c.get("/v1/catalog/services", url.Values{}, index, &catalog)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var services []string
+	for name, tags := range catalog {
+		if c.Tag == "" {
+			services = append(services, name)
+			continue
+		}
+		for _, tag := range tags {
+			if tag == c.Tag {
+				services = append(services, name)
+				break
+			}
+		}
+	}
+	sort.Strings(services)
+	return services, index, nil
+}
+
+func (c *ConsulSource) healthParams() url.Values {
+	params := url.Values{}
+	if c.Tag != "" {
+		params.Set("tag", c.Tag)
+	}
+	if c.Passing {
+		params.Set("passing", "1")
+	}
+	return params
+}
+
+// get queries the Consul HTTP API, decoding the response into v.  If index
+// is non-zero the query blocks until the result changes from index, or the
+// wait time elapses.  The index of the result is returned.
+func (c *ConsulSource) get(path string, params url.Values, index uint64, v interface{}) (uint64, error) {
+	return c.getContext(context.Background(), path, params, index, v)
+}
+
+func (c *ConsulSource) getContext(ctx context.Context, path string, params url.Values, index uint64, v interface{}) (uint64, error) {
+	address := c.Address
+	if address == "" {
+		address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if address == "" {
+		address = "http://127.0.0.1:8500"
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	if c.Datacenter != "" {
+		params.Set("dc", c.Datacenter)
+	}
+	timeout := FetchTimeout
+	if index != 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", consulWait.String())
+		// Consul adds up to wait/16 of jitter
+		timeout = consulWait + consulWait/16 + FetchTimeout
+	}
+
+	u := strings.TrimSuffix(address, "/") + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	token := c.Token
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("consul: querying %s: received HTTP status %d", u, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return 0, err
+	}
+
+	newIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return newIndex, nil
+}
+
+// watch follows changes to the catalog and to the instances of each
+// matching service with blocking queries, calling notify for every change
+// until stop is closed.
+func (c *ConsulSource) watch(notify func(), stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	delay := time.Second
+	for {
+		err := c.watchOnce(ctx, notify)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// the matching services changed
+			delay = time.Second
+			continue
+		}
+
+		log.Printf("consul: watch failed, retrying in %s: %s", delay, err)
+		if !sleepOrStop(delay, stop) {
+			return
+		}
+		if delay *= 2; delay > time.Minute {
+			delay = time.Minute
+		}
+	}
+}
+
+// watchOnce watches the instances of the currently matching services until
+// the set of matching services changes, or a query fails.
+func (c *ConsulSource) watchOnce(ctx context.Context, notify func()) error {
+	services, catalogIndex, err := c.services(0)
+	if err != nil {
+		return err
+	}
+
+	servicesCtx, servicesChanged := context.WithCancel(ctx)
+	defer servicesChanged()
+
+	errs := make(chan error, len(services)+1)
+	for _, service := range services {
+		go func(path string) {
+			errs <- c.block(servicesCtx, path, c.healthParams, 0, notify)
+		}("/v1/health/service/" + url.PathEscape(service))
+	}
+	if c.Service == "" {
+		go func() {
+			errs <- c.block(servicesCtx, "/v1/catalog/services", func() url.Values { return url.Values{} }, catalogIndex, func() {
+				notify()
+				servicesChanged()
+			})
+		}()
+	}
+
+	err = <-errs
+	if servicesCtx.Err() != nil && ctx.Err() == nil {
+		return nil
+	}
+	return err
+}
+
+// block repeats a blocking query until it fails or ctx is done, calling
+// changed whenever the index of the result moves on.  An index of zero
+// makes the first query return immediately, without calling changed.
+func (c *ConsulSource) block(ctx context.Context, path string, params func() url.Values, index uint64, changed func()) error {
+	for {
+		var result json.RawMessage
+		newIndex, err := c.getContext(ctx, path, params(), index, &result)
+		if err != nil {
+			return err
+		}
+
+		if index != 0 && newIndex != index {
+			changed()
+		}
+
+		// indexes must be positive, and are reset if they go backwards
+		if newIndex < index || newIndex == 0 {
+			newIndex = 1
+		}
+		index = newIndex
+	}
+}
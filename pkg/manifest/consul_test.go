@@ -0,0 +1,174 @@
+package manifest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const consulCatalog = `{
+	"consul": [],
+	"api": ["http", "internal"],
+	"web": ["http"]
+}`
+
+const consulWeb = `[
+	{
+		"Node": {"Node": "node-b", "Address": "10.0.0.2"},
+		"Service": {"ID": "web-2", "Service": "web", "Tags": ["http", "v2"], "Port": 8080, "Meta": {"path": "/healthz"}}
+	},
+	{
+		"Node": {"Node": "node-a", "Address": "10.0.0.1"},
+		"Service": {"ID": "web-1", "Service": "web", "Tags": ["http"], "Address": "192.168.0.1", "Port": 8080, "Meta": {"path": "/healthz"}}
+	}
+]`
+
+const consulAPI = `[
+	{
+		"Node": {"Node": "node-a", "Address": "10.0.0.1"},
+		"Service": {"ID": "api-1", "Service": "api", "Tags": ["http", "internal"], "Port": 9000, "Meta": {"path": "/status"}}
+	}
+]`
+
+func TestLoaderWithConsul(t *testing.T) {
+	var token, tag, passing string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Consul-Token")
+		w.Header().Set("X-Consul-Index", "10")
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			fmt.Fprint(w, consulCatalog)
+		case "/v1/health/service/web":
+			tag, passing = r.URL.Query().Get("tag"), r.URL.Query().Get("passing")
+			fmt.Fprint(w, consulWeb)
+		case "/v1/health/service/api":
+			fmt.Fprint(w, consulAPI)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+	api := httptest.NewServer(http.HandlerFunc(handler))
+	defer api.Close()
+
+	data := fmt.Sprintf(`{
+		"targets": [
+			{
+				"name": "consul",
+				"url": "http://{address}:{port}{meta.path}",
+				"consul": {"address": "%s", "token": "secret", "tag": "http", "passing": true}
+			}
+		]
+	}`, api.URL)
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	m, err := NewLoader([]string{ts.URL}, 42).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "secret" {
+		t.Fatalf("expected the token to be sent, got %q", token)
+	}
+	if tag != "http" || passing != "1" {
+		t.Fatalf("expected instances to be filtered by tag and health, got tag %q and passing %q", tag, passing)
+	}
+
+	expected := []struct{ name, url string }{
+		{"consul-api-node-a-9000", "http://10.0.0.1:9000/status"},
+		{"consul-web-node-a-8080", "http://192.168.0.1:8080/healthz"},
+		{"consul-web-node-b-8080", "http://10.0.0.2:8080/healthz"},
+	}
+	if len(m.Targets) != len(expected) {
+		t.Fatalf("%d targets found, but expected %d", len(m.Targets), len(expected))
+	}
+	for i, e := range expected {
+		target := m.Targets[i]
+		if target.Name != e.name || target.URL.String() != e.url {
+			t.Errorf("expected target %d to be %s at %s, got %s at %s", i, e.name, e.url, target.Name, target.URL)
+		}
+	}
+
+	web := m.Targets[2]
+	if len(web.Tags) != 2 || web.Tags[1] != "v2" {
+		t.Errorf("expected the service tags to be added, got %v", web.Tags)
+	}
+	if web.Attributes["id"] != "web-2" || web.Attributes["meta.path"] != "/healthz" {
+		t.Errorf("expected variables as attributes, got %v", web.Attributes)
+	}
+}
+
+func TestLoaderRejectsUnknownConsulVariables(t *testing.T) {
+	data := `{
+		"targets": [
+			{"name": "consul", "url": "http://{host}/", "consul": {"service": "web"}}
+		]
+	}`
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	_, err := NewLoader([]string{ts.URL}, 42).Load()
+	verr, ok := err.(ValidationError)
+	if !ok || len(verr) != 1 || verr[0].Path() != "targets[0].url" {
+		t.Fatalf("expected a single problem at targets[0].url, got %v", err)
+	}
+}
+
+func TestLoaderWatchesConsul(t *testing.T) {
+	changes := make(chan bool)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/web" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.URL.Query().Get("index") {
+		case "":
+			w.Header().Set("X-Consul-Index", "10")
+		case "10":
+			select {
+			case <-changes:
+				w.Header().Set("X-Consul-Index", "11")
+			case <-r.Context().Done():
+				return
+			}
+		default:
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, consulWeb)
+	}
+	api := httptest.NewServer(http.HandlerFunc(handler))
+	defer api.Close()
+
+	data := fmt.Sprintf(`{
+		"targets": [
+			{"name": "web", "consul": {"address": "%s", "service": "web", "watch": true}}
+		]
+	}`, api.URL)
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	l := NewLoader([]string{ts.URL}, 42)
+	if _, err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	changes <- true
+	select {
+	case <-l.Changes():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a change to be reported")
+	}
+
+	// the watch stops once the source is no longer in the manifest
+	data = `{"targets": []}`
+	if _, err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.watching) != 0 {
+		t.Fatalf("expected no watches, got %d", len(l.watching))
+	}
+}
@@ -0,0 +1,107 @@
+package manifest
+
+import "github.com/canaryio/canary/pkg/sampler"
+
+// TargetDefaults holds target settings that can be shared by several
+// targets, through the manifest's defaults block or a named group.
+type TargetDefaults struct {
+	Interval           int
+	Tags               []string
+	Attributes         map[string]string
+	RequestHeaders     map[string]string
+	InsecureSkipVerify *bool
+	ExpectedStatus     int
+	ConfirmFailures    int
+	ConfirmRecoveries  int
+	RetryFailures      *bool
+	FlapWindow         int
+	FlapThreshold      int
+}
+
+// merge layers d over base: set fields in d override those in base, maps
+// are merged key by key, and tags are combined.
+func (base TargetDefaults) merge(d TargetDefaults) TargetDefaults {
+	if d.Interval != 0 {
+		base.Interval = d.Interval
+	}
+	if d.InsecureSkipVerify != nil {
+		base.InsecureSkipVerify = d.InsecureSkipVerify
+	}
+	if d.ExpectedStatus != 0 {
+		base.ExpectedStatus = d.ExpectedStatus
+	}
+	if d.ConfirmFailures != 0 {
+		base.ConfirmFailures = d.ConfirmFailures
+	}
+	if d.ConfirmRecoveries != 0 {
+		base.ConfirmRecoveries = d.ConfirmRecoveries
+	}
+	if d.RetryFailures != nil {
+		base.RetryFailures = d.RetryFailures
+	}
+	if d.FlapWindow != 0 {
+		base.FlapWindow = d.FlapWindow
+	}
+	if d.FlapThreshold != 0 {
+		base.FlapThreshold = d.FlapThreshold
+	}
+	base.Tags = mergeTags(base.Tags, d.Tags)
+	base.Attributes = mergeMaps(base.Attributes, d.Attributes)
+	base.RequestHeaders = mergeMaps(base.RequestHeaders, d.RequestHeaders)
+	return base
+}
+
+// apply sets the fields of t from d.
+func (d TargetDefaults) apply(t *sampler.Target) {
+	t.Interval = d.Interval
+	t.Tags = d.Tags
+	t.Attributes = d.Attributes
+	t.RequestHeaders = d.RequestHeaders
+	t.ExpectedStatus = d.ExpectedStatus
+	t.ConfirmFailures = d.ConfirmFailures
+	t.ConfirmRecoveries = d.ConfirmRecoveries
+	t.FlapWindow = d.FlapWindow
+	t.FlapThreshold = d.FlapThreshold
+	if d.InsecureSkipVerify != nil {
+		t.InsecureSkipVerify = *d.InsecureSkipVerify
+	}
+	if d.RetryFailures != nil {
+		t.RetryFailures = *d.RetryFailures
+	}
+}
+
+// mergeTags returns the tags of a followed by those of b that are not
+// already present.
+func mergeTags(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+
+	merged := make([]string, 0, len(a)+len(b))
+	seen := make(map[string]bool)
+	for _, tags := range [][]string{a, b} {
+		for _, tag := range tags {
+			if !seen[tag] {
+				seen[tag] = true
+				merged = append(merged, tag)
+			}
+		}
+	}
+	return merged
+}
+
+// mergeMaps returns a new map with the entries of a, overridden by those of b.
+func mergeMaps(a, b map[string]string) map[string]string {
+	if len(b) == 0 {
+		return a
+	}
+
+	merged := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,159 @@
+package manifest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const groupsManifest = `{
+	"defaults": {
+		"interval": 10,
+		"tags": ["canary"],
+		"requestHeaders": {"User-Agent": "canary"},
+		"confirmFailures": 3
+	},
+	"groups": {
+		"internal": {
+			"interval": 5,
+			"tags": ["internal"],
+			"insecureSkipVerify": true,
+			"retryFailures": true,
+			"attributes": {"team": "ops"}
+		}
+	},
+	"targets": [
+		{
+			"url": "http://www.canary.io",
+			"name": "canary"
+		},
+		{
+			"url": "https://admin.canary.io",
+			"name": "admin",
+			"group": "internal"
+		},
+		{
+			"url": "https://api.canary.io",
+			"name": "api",
+			"group": "internal",
+			"interval": 1,
+			"insecureSkipVerify": false,
+			"confirmFailures": 2,
+			"tags": ["api", "canary"],
+			"requestHeaders": {"User-Agent": "canary-api"}
+		}
+	]
+}`
+
+func serveManifest(data *string) *httptest.Server {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, *data)
+	}
+	return httptest.NewServer(http.HandlerFunc(handler))
+}
+
+func TestGetWithDefaultsAndGroups(t *testing.T) {
+	data := groupsManifest
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	m, err := Get(ts.URL, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canary, admin, api := m.Targets[0], m.Targets[1], m.Targets[2]
+
+	if canary.Interval != 10 || admin.Interval != 5 || api.Interval != 1 {
+		t.Fatalf("expected intervals 10, 5 and 1, got %d, %d and %d", canary.Interval, admin.Interval, api.Interval)
+	}
+
+	if canary.InsecureSkipVerify || !admin.InsecureSkipVerify || api.InsecureSkipVerify {
+		t.Fatalf("expected InsecureSkipVerify false, true and false, got %t, %t and %t", canary.InsecureSkipVerify, admin.InsecureSkipVerify, api.InsecureSkipVerify)
+	}
+
+	if canary.ConfirmFailures != 3 || admin.ConfirmFailures != 3 || api.ConfirmFailures != 2 {
+		t.Fatalf("expected ConfirmFailures 3, 3 and 2, got %d, %d and %d", canary.ConfirmFailures, admin.ConfirmFailures, api.ConfirmFailures)
+	}
+	if canary.RetryFailures || !admin.RetryFailures || !api.RetryFailures {
+		t.Fatalf("expected RetryFailures false, true and true, got %t, %t and %t", canary.RetryFailures, admin.RetryFailures, api.RetryFailures)
+	}
+
+	if fmt.Sprint(admin.Tags) != "[canary internal]" {
+		t.Fatalf("expected admin tags to be [canary internal], got %v", admin.Tags)
+	}
+	if fmt.Sprint(api.Tags) != "[canary internal api]" {
+		t.Fatalf("expected api tags to be [canary internal api], got %v", api.Tags)
+	}
+
+	if canary.Attributes != nil {
+		t.Fatalf("expected canary to have no attributes, got %v", canary.Attributes)
+	}
+	if admin.Attributes["team"] != "ops" {
+		t.Fatalf("expected admin to inherit the team attribute, got %v", admin.Attributes)
+	}
+
+	if admin.RequestHeaders["User-Agent"] != "canary" || api.RequestHeaders["User-Agent"] != "canary-api" {
+		t.Fatalf("expected User-Agent headers canary and canary-api, got %v and %v", admin.RequestHeaders, api.RequestHeaders)
+	}
+}
+
+func TestGetGroupChangeOnlyAffectsMembers(t *testing.T) {
+	data := groupsManifest
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	before, err := Get(ts.URL, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data = `{
+		"defaults": {"interval": 10, "tags": ["canary"], "requestHeaders": {"User-Agent": "canary"}, "confirmFailures": 3},
+		"groups": {
+			"internal": {"interval": 7, "tags": ["internal"], "insecureSkipVerify": true, "retryFailures": true, "attributes": {"team": "ops"}}
+		},
+		"targets": [
+			{"url": "http://www.canary.io", "name": "canary"},
+			{"url": "https://admin.canary.io", "name": "admin", "group": "internal"},
+			{"url": "https://api.canary.io", "name": "api", "group": "internal", "interval": 1,
+			 "insecureSkipVerify": false, "confirmFailures": 2, "tags": ["api", "canary"], "requestHeaders": {"User-Agent": "canary-api"}}
+		]
+	}`
+	after, err := Get(ts.URL, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before.Targets[0].Hash != after.Targets[0].Hash {
+		t.Error("expected the hash of canary, outside the group, to be unchanged")
+	}
+	if before.Targets[1].Hash == after.Targets[1].Hash {
+		t.Error("expected the hash of admin, which inherits the group interval, to change")
+	}
+	if before.Targets[2].Hash != after.Targets[2].Hash {
+		t.Error("expected the hash of api, which overrides the group interval, to be unchanged")
+	}
+}
+
+func TestGetWithUnknownGroup(t *testing.T) {
+	data := `{
+		"targets": [
+			{"url": "http://www.canary.io", "name": "canary", "group": "missing"},
+			{"url": "ftp://www.canary.io", "name": "ftp"}
+		]
+	}`
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	_, err := Get(ts.URL, 42)
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	if len(verr) != 2 || verr[0].Path() != "targets[0].group" || verr[1].Path() != "targets[1].url" {
+		t.Fatalf("expected problems at targets[0].group and targets[1].url, got %s", verr)
+	}
+}
@@ -0,0 +1,68 @@
+package manifest
+
+import "time"
+
+// instance is a single target found by a discoverer.
+type instance struct {
+	vars       map[string]string // template variables, also recorded as attributes
+	unrecorded []string          // vars not recorded, as they change without the instance changing
+	attributes map[string]string // additional attributes
+	settings   TargetDefaults    // settings overriding those of the target
+}
+
+// discoverer expands a target into the instances found in a registry.
+type discoverer interface {
+	// field returns the name of the target field configuring the discoverer.
+	field() string
+
+	// variables returns the template variables set for each instance.
+	variables() []string
+
+	// nameVariables returns the variables that tell instances apart, in
+	// the order their values are appended to generated target names.
+	nameVariables() []string
+
+	// defaultURL returns the URL template used when the target has none.
+	defaultURL() string
+
+	// discover returns the instances currently in the registry.  vars are
+	// the matrix variables of the target being expanded.
+	discover(vars map[string]string) ([]instance, error)
+}
+
+// watcher is a discoverer that can report changes as they happen, rather
+// than waiting for the next reload.
+type watcher interface {
+	discoverer
+
+	// watchKey identifies the watch, so that it is only started once.  It
+	// is empty if the discoverer should not be watched.
+	watchKey() string
+
+	// watch calls notify whenever the registry changes, until stop is closed.
+	watch(notify func(), stop <-chan struct{})
+}
+
+// discoverers returns the discoverers configured for the spec.
+func (spec targetSpec) discoverers() (ds []discoverer) {
+	if spec.SRV != "" {
+		ds = append(ds, srvDiscoverer(spec.SRV))
+	}
+	if spec.Kubernetes != nil {
+		ds = append(ds, spec.Kubernetes)
+	}
+	if spec.Consul != nil {
+		ds = append(ds, spec.Consul)
+	}
+	return
+}
+
+// sleepOrStop waits for d, returning false if stop was closed first.
+func sleepOrStop(d time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-stop:
+		return false
+	}
+}
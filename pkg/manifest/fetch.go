@@ -0,0 +1,76 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrNotModified is returned by fetchHTTP when a conditional request reports
+// that the manifest has not changed since it was last retrieved.
+var ErrNotModified = errors.New("manifest not modified")
+
+// FetchTimeout bounds the time taken to retrieve a manifest over HTTP.
+var FetchTimeout = 10 * time.Second
+
+// validators are the response values used to make conditional requests.
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// fetch retrieves the manifest document at a file:// or HTTP url,
+// unconditionally.
+func fetch(url string) (body []byte, err error) {
+	if strings.HasPrefix(url, "file://") {
+		return ioutil.ReadFile(url[7:])
+	}
+
+	body, _, err = fetchHTTP(url, validators{})
+	return
+}
+
+// fetchHTTP retrieves the manifest at url, sending the previous validators,
+// if any.  ErrNotModified is returned when the server responds with 304.
+func fetchHTTP(url string, previous validators) (body []byte, v validators, err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return
+	}
+
+	if previous.etag != "" {
+		req.Header.Set("If-None-Match", previous.etag)
+	}
+	if previous.lastModified != "" {
+		req.Header.Set("If-Modified-Since", previous.lastModified)
+	}
+
+	client := &http.Client{Timeout: FetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		err = ErrNotModified
+		return
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("fetching manifest from %s: received HTTP status %d", url, resp.StatusCode)
+		return
+	}
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	v.etag = resp.Header.Get("ETag")
+	v.lastModified = resp.Header.Get("Last-Modified")
+	return
+}
@@ -0,0 +1,98 @@
+package manifest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const etagManifest = `{
+	"targets": [
+		{
+			"url": "http://www.canary.io",
+			"name": "canary"
+		}
+	]
+}`
+
+func TestLoaderConditional(t *testing.T) {
+	requests := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, etagManifest)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	l := NewLoader([]string{ts.URL}, 42)
+	for i := 0; i < 2; i++ {
+		m, err := l.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Targets) != 1 {
+			t.Fatalf("%d targets found on load %d, but expected 1", len(m.Targets), i)
+		}
+	}
+
+	// Get does not share the loader's validators, nor keep its own
+	for i := 0; i < 2; i++ {
+		if _, err := Get(ts.URL, 42); err != nil {
+			t.Fatalf("expected the whole manifest on request %d, got %v", i, err)
+		}
+	}
+
+	if requests != 4 {
+		t.Fatalf("expected 4 requests, got %d", requests)
+	}
+}
+
+func TestLoaderConditionalAfterInvalidManifest(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+		fmt.Fprint(w, `{"targets": [{"name": "canary"}]}`)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	l := NewLoader([]string{ts.URL}, 42)
+	for i := 0; i < 2; i++ {
+		_, err := l.Load()
+		if _, ok := err.(ValidationError); !ok {
+			t.Fatalf("expected a ValidationError on load %d, got %v", i, err)
+		}
+	}
+}
+
+func TestGetNon2xx(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>oops</html>", http.StatusInternalServerError)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	_, err := Get(ts.URL, 42)
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "HTTP status 500") {
+		t.Fatalf("expected '%s' to contain 'HTTP status 500'", err)
+	}
+}
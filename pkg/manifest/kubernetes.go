@@ -0,0 +1,453 @@
+package manifest
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// the service account credentials mounted into every pod
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	// KubernetesAnnotationPrefix prefixes the annotations that carry
+	// per-target settings on Ingresses and Services.
+	KubernetesAnnotationPrefix = "canary.io/"
+)
+
+// KubernetesSource discovers targets from the Ingresses or Services in a
+// Kubernetes cluster.
+//
+// Each Ingress rule host, or each Service port, becomes one instance, with
+// the template variables namespace, name, host, port, scheme and path.  The
+// labels of the object are recorded as attributes.  Settings are read from
+// annotations prefixed with KubernetesAnnotationPrefix:
+//
+//	canary.io/path            request path, defaulting to "/"
+//	canary.io/scheme          http or https
+//	canary.io/port            the Service port to monitor, by name or number
+//	canary.io/interval        sample interval in seconds
+//	canary.io/expected-status the HTTP status the target should respond with
+type KubernetesSource struct {
+	// APIServer is the URL of the API server.  If empty, the in-cluster
+	// API server is used, with the pod's service account credentials.
+	APIServer string
+	TokenFile string
+	CAFile    string
+
+	Kind          string // "ingress" or "service"
+	Namespace     string // all namespaces if empty
+	LabelSelector string
+	Annotation    string // if set, only objects with this annotation set to "true"
+	Watch         bool   // reload as soon as objects change
+}
+
+// kubernetesMeta is the object metadata common to Ingresses and Services.
+type kubernetesMeta struct {
+	Name        string
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+type kubernetesList struct {
+	Metadata struct {
+		ResourceVersion string
+	}
+	Items []kubernetesObject
+}
+
+// kubernetesObject holds the fields of Ingresses and Services that are
+// needed to synthesize targets.
+type kubernetesObject struct {
+	Metadata kubernetesMeta
+	Spec     struct {
+		// Ingress
+		Rules []struct {
+			Host string
+		}
+		TLS []struct {
+			Hosts []string
+		}
+
+		// Service
+		Ports []struct {
+			Name string
+			Port int
+		}
+	}
+}
+
+type kubernetesEvent struct {
+	Type   string
+	Object json.RawMessage
+}
+
+func (k *KubernetesSource) field() string {
+	return "kubernetes"
+}
+
+func (k *KubernetesSource) variables() []string {
+	return []string{"namespace", "name", "host", "port", "scheme", "path"}
+}
+
+func (k *KubernetesSource) nameVariables() []string {
+	return []string{"namespace", "name", "host", "port"}
+}
+
+func (k *KubernetesSource) defaultURL() string {
+	return "{scheme}://{host}:{port}{path}"
+}
+
+func (k *KubernetesSource) watchKey() string {
+	if !k.Watch {
+		return ""
+	}
+	b, _ := json.Marshal(k)
+	return "kubernetes:" + string(b)
+}
+
+// discover lists the objects in the cluster and returns an instance for
+// each Ingress host or Service port.
+func (k *KubernetesSource) discover(vars map[string]string) ([]instance, error) {
+	client, base, err := k.client()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := k.list(client, base)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []instance
+	for _, obj := range list.Items {
+		if k.Annotation != "" && obj.Metadata.Annotations[k.Annotation] != "true" {
+			continue
+		}
+		instances = append(instances, k.instances(obj)...)
+	}
+	return instances, nil
+}
+
+// instances synthesizes the instances of a single object.
+func (k *KubernetesSource) instances(obj kubernetesObject) (instances []instance) {
+	meta := obj.Metadata
+	annotation := func(name string) string {
+		return meta.Annotations[KubernetesAnnotationPrefix+name]
+	}
+
+	// an invalid setting is logged and left unset, as it would otherwise
+	// fail the validation of the whole manifest
+	setting := func(name string, valid func(int) bool) int {
+		s := annotation(name)
+		if s == "" {
+			return 0
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || !valid(v) {
+			log.Printf("kubernetes: ignoring invalid %s%s on %s/%s: %s", KubernetesAnnotationPrefix, name, meta.Namespace, meta.Name, s)
+			return 0
+		}
+		return v
+	}
+
+	var settings TargetDefaults
+	settings.Interval = setting("interval", func(v int) bool { return v > 0 })
+	settings.ExpectedStatus = setting("expected-status", func(v int) bool { return v >= 100 && v <= 599 })
+
+	path := annotation("path")
+	if path == "" {
+		path = "/"
+	}
+
+	add := func(host, scheme string, port int) {
+		if s := annotation("scheme"); s != "" {
+			scheme = s
+		}
+		instances = append(instances, instance{
+			vars: map[string]string{
+				"namespace": meta.Namespace,
+				"name":      meta.Name,
+				"host":      host,
+				"port":      strconv.Itoa(port),
+				"scheme":    scheme,
+				"path":      path,
+			},
+			attributes: meta.Labels,
+			settings:   settings,
+		})
+	}
+
+	switch k.Kind {
+	case "ingress":
+		tlsHosts := make(map[string]bool)
+		for _, t := range obj.Spec.TLS {
+			for _, h := range t.Hosts {
+				tlsHosts[h] = true
+			}
+		}
+		for _, rule := range obj.Spec.Rules {
+			if rule.Host == "" {
+				continue
+			}
+			if tlsHosts[rule.Host] {
+				add(rule.Host, "https", 443)
+			} else {
+				add(rule.Host, "http", 80)
+			}
+		}
+	case "service":
+		host := fmt.Sprintf("%s.%s.svc", meta.Name, meta.Namespace)
+		only := annotation("port")
+		for _, p := range obj.Spec.Ports {
+			if only != "" && only != p.Name && only != strconv.Itoa(p.Port) {
+				continue
+			}
+			scheme := "http"
+			if p.Name == "https" || p.Port == 443 {
+				scheme = "https"
+			}
+			add(host, scheme, p.Port)
+		}
+	}
+
+	return
+}
+
+// path returns the API path of the objects being discovered.
+func (k *KubernetesSource) path() (string, error) {
+	var prefix, resource string
+	switch k.Kind {
+	case "ingress":
+		prefix, resource = "/apis/networking.k8s.io/v1", "ingresses"
+	case "service":
+		prefix, resource = "/api/v1", "services"
+	default:
+		return "", fmt.Errorf("unknown kind %q, must be ingress or service", k.Kind)
+	}
+
+	if k.Namespace != "" {
+		return fmt.Sprintf("%s/namespaces/%s/%s", prefix, url.PathEscape(k.Namespace), resource), nil
+	}
+	return prefix + "/" + resource, nil
+}
+
+// query returns the URL of the objects being discovered, with extra query
+// parameters.
+func (k *KubernetesSource) query(base string, params url.Values) (string, error) {
+	path, err := k.path()
+	if err != nil {
+		return "", err
+	}
+
+	if k.LabelSelector != "" {
+		params.Set("labelSelector", k.LabelSelector)
+	}
+	u := strings.TrimSuffix(base, "/") + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	return u, nil
+}
+
+// kubernetesTransport adds the bearer token to every request.
+type kubernetesTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *kubernetesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// client returns an HTTP client for the API server, and its base URL.
+func (k *KubernetesSource) client() (*http.Client, string, error) {
+	base, tokenFile, caFile := k.APIServer, k.TokenFile, k.CAFile
+	if base == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, "", fmt.Errorf("kubernetes: no apiServer set and not running in a cluster")
+		}
+		base = "https://" + net.JoinHostPort(host, port)
+		if tokenFile == "" {
+			tokenFile = serviceAccountToken
+		}
+		if caFile == "" {
+			caFile = serviceAccountCA
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("kubernetes: reading CA: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, "", fmt.Errorf("kubernetes: no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	var token string
+	if tokenFile != "" {
+		b, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("kubernetes: reading token: %s", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	client := &http.Client{
+		Transport: &kubernetesTransport{token: token, base: transport},
+	}
+	return client, base, nil
+}
+
+// list retrieves the objects being discovered.
+func (k *KubernetesSource) list(client *http.Client, base string) (list kubernetesList, err error) {
+	u, err := k.query(base, url.Values{})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), FetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("kubernetes: listing %s: received HTTP status %d", u, resp.StatusCode)
+		return
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	return
+}
+
+// watch follows changes to the objects being discovered, calling notify
+// for every change until stop is closed.  The watch is restarted a second
+// after it ends, so that streams closed at once do not flood the API
+// server, and with backoff after it fails.
+func (k *KubernetesSource) watch(notify func(), stop <-chan struct{}) {
+	delay := time.Second
+	for {
+		err := k.watchOnce(notify, stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err == nil {
+			// the API server ends watches periodically
+			delay = time.Second
+			if !sleepOrStop(delay, stop) {
+				return
+			}
+			continue
+		}
+
+		log.Printf("kubernetes: watch failed, retrying in %s: %s", delay, err)
+		if !sleepOrStop(delay, stop) {
+			return
+		}
+		if delay *= 2; delay > time.Minute {
+			delay = time.Minute
+		}
+	}
+}
+
+// watchOnce lists the objects to find the current resource version, then
+// watches for changes from there until the API server ends the watch.
+func (k *KubernetesSource) watchOnce(notify func(), stop <-chan struct{}) error {
+	client, base, err := k.client()
+	if err != nil {
+		return err
+	}
+
+	list, err := k.list(client, base)
+	if err != nil {
+		return err
+	}
+
+	u, err := k.query(base, url.Values{
+		"watch":           {"1"},
+		"resourceVersion": {list.Metadata.ResourceVersion},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+
+	// closing stop ends the watch
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kubernetes: watching %s: received HTTP status %d", u, resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var event kubernetesEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return err
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			notify()
+		case "ERROR":
+			// typically 410 Gone, once the resource version is too old
+			return fmt.Errorf("kubernetes: watch error: %s", event.Object)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,228 @@
+package manifest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const ingressList = `{
+	"metadata": {"resourceVersion": "100"},
+	"items": [
+		{
+			"metadata": {
+				"name": "web",
+				"namespace": "shop",
+				"labels": {"app": "web", "team": "storefront"},
+				"annotations": {
+					"canary.io/enabled": "true",
+					"canary.io/path": "/healthz",
+					"canary.io/interval": "5",
+					"canary.io/expected-status": "204"
+				}
+			},
+			"spec": {
+				"rules": [{"host": "shop.example.com"}, {"host": "www.example.com"}],
+				"tls": [{"hosts": ["shop.example.com"]}]
+			}
+		},
+		{
+			"metadata": {"name": "internal", "namespace": "shop"},
+			"spec": {"rules": [{"host": "internal.example.com"}]}
+		}
+	]
+}`
+
+const serviceList = `{
+	"metadata": {"resourceVersion": "200"},
+	"items": [
+		{
+			"metadata": {
+				"name": "api",
+				"namespace": "default",
+				"labels": {"app": "api"},
+				"annotations": {
+					"canary.io/port": "https",
+					"canary.io/interval": "-5",
+					"canary.io/expected-status": "2OO"
+				}
+			},
+			"spec": {"ports": [{"name": "metrics", "port": 9090}, {"name": "https", "port": 8443}]}
+		}
+	]
+}`
+
+func TestLoaderWithKubernetes(t *testing.T) {
+	var authorization, selector string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/apis/networking.k8s.io/v1/ingresses":
+			selector = r.URL.Query().Get("labelSelector")
+			fmt.Fprint(w, ingressList)
+		case "/api/v1/namespaces/default/services":
+			fmt.Fprint(w, serviceList)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+	api := httptest.NewServer(http.HandlerFunc(handler))
+	defer api.Close()
+
+	data := fmt.Sprintf(`{
+		"targets": [
+			{
+				"name": "ingress",
+				"kubernetes": {
+					"apiServer": "%s",
+					"kind": "ingress",
+					"labelSelector": "tier=frontend",
+					"annotation": "canary.io/enabled"
+				}
+			},
+			{
+				"name": "svc-{name}",
+				"url": "{scheme}://{host}:{port}/status",
+				"kubernetes": {
+					"apiServer": "%s",
+					"kind": "service",
+					"namespace": "default"
+				}
+			}
+		]
+	}`, api.URL, api.URL)
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	m, err := NewLoader([]string{ts.URL}, 42).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if authorization != "" {
+		t.Fatalf("expected no credentials to be sent to an explicit API server, got %s", authorization)
+	}
+	if selector != "tier=frontend" {
+		t.Fatalf("expected the label selector to be sent, got %q", selector)
+	}
+
+	expected := []struct{ name, url string }{
+		{"ingress-shop-web-shop.example.com-443", "https://shop.example.com:443/healthz"},
+		{"ingress-shop-web-www.example.com-80", "http://www.example.com:80/healthz"},
+		{"svc-api", "https://api.default.svc:8443/status"},
+	}
+	if len(m.Targets) != len(expected) {
+		t.Fatalf("%d targets found, but expected %d", len(m.Targets), len(expected))
+	}
+	for i, e := range expected {
+		target := m.Targets[i]
+		if target.Name != e.name || target.URL.String() != e.url {
+			t.Errorf("expected target %d to be %s at %s, got %s at %s", i, e.name, e.url, target.Name, target.URL)
+		}
+	}
+
+	web := m.Targets[0]
+	if web.Interval != 5 || web.ExpectedStatus != 204 {
+		t.Errorf("expected annotations to set interval 5 and expected status 204, got %d and %d", web.Interval, web.ExpectedStatus)
+	}
+	if api := m.Targets[2]; api.Interval != 42 || api.ExpectedStatus != 0 {
+		t.Errorf("expected invalid annotations to be ignored, got interval %d and expected status %d", api.Interval, api.ExpectedStatus)
+	}
+	if web.Attributes["team"] != "storefront" || web.Attributes["namespace"] != "shop" {
+		t.Errorf("expected labels and variables as attributes, got %v", web.Attributes)
+	}
+}
+
+func TestLoaderWatchesKubernetes(t *testing.T) {
+	events := make(chan string)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "" {
+			fmt.Fprint(w, serviceList)
+			return
+		}
+
+		if r.URL.Query().Get("resourceVersion") != "200" {
+			t.Errorf("expected the watch to start from the listed resource version, got %s", r.URL.RawQuery)
+		}
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-events:
+				fmt.Fprintf(w, `{"type": "%s", "object": {}}`+"\n", event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	api := httptest.NewServer(http.HandlerFunc(handler))
+	defer api.Close()
+
+	data := fmt.Sprintf(`{
+		"targets": [
+			{
+				"name": "svc",
+				"kubernetes": {"apiServer": "%s", "kind": "service", "namespace": "default", "watch": true}
+			}
+		]
+	}`, api.URL)
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	l := NewLoader([]string{ts.URL}, 42)
+	if _, err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	events <- "MODIFIED"
+	select {
+	case <-l.Changes():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a change to be reported")
+	}
+
+	// the watch stops once the source is no longer in the manifest
+	data = `{"targets": []}`
+	if _, err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.watching) != 0 {
+		t.Fatalf("expected no watches, got %d", len(l.watching))
+	}
+}
+
+func TestKubernetesWatchRestartsAfterADelay(t *testing.T) {
+	var mu sync.Mutex
+	watches := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "" {
+			fmt.Fprint(w, serviceList)
+			return
+		}
+		// end every watch at once
+		mu.Lock()
+		watches++
+		mu.Unlock()
+	}))
+	defer api.Close()
+
+	k := &KubernetesSource{APIServer: api.URL, Kind: "service", Namespace: "default", Watch: true}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		k.watch(func() {}, stop)
+		close(done)
+	}()
+	time.Sleep(1500 * time.Millisecond)
+	close(stop)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if watches != 2 {
+		t.Fatalf("expected the watch to be restarted once in 1.5s, got %d watches", watches)
+	}
+}
@@ -0,0 +1,217 @@
+package manifest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SourceAttribute is the target attribute in which a Loader records the
+// source each target was loaded from.
+const SourceAttribute = "source"
+
+// Loader loads manifests from several sources and merges them into a single
+// Manifest.  A source is an HTTP URL, or a file:// URL naming a file, a
+// directory of *.json files or a glob pattern.
+//
+// A Loader remembers what it last loaded from each source, so that only the
+// sources that changed are parsed again.  Files are read on every load and
+// compared by content, as a replaced file may keep its size and mtime.  Discovered targets,
+// such as those from SRV records, are resolved again on every load.
+//
+// Discovery sources that can watch for changes, such as Kubernetes with
+// watch enabled, are watched from the first load in which they appear until
+// the first in which they no longer do.  Changes are reported on Changes.
+type Loader struct {
+	Sources         []string
+	DefaultInterval int
+
+	mu       sync.Mutex
+	cache    map[string]cachedSource
+	changes  chan struct{}
+	watching map[string]chan struct{} // stop channels, by watch key
+}
+
+// cachedSource is what was last loaded from a single manifest location.
+type cachedSource struct {
+	sum        string // MD5 of the file's content
+	validators validators
+	doc        document
+}
+
+// NewLoader returns a pointer to a new Loader.
+func NewLoader(sources []string, defaultInterval int) *Loader {
+	return &Loader{
+		Sources:         sources,
+		DefaultInterval: defaultInterval,
+		cache:           make(map[string]cachedSource),
+		changes:         make(chan struct{}, 1),
+		watching:        make(map[string]chan struct{}),
+	}
+}
+
+// Changes returns a channel that receives whenever a watched discovery
+// source reports a change.  Changes that arrive before the last one has
+// been received are coalesced.
+func (l *Loader) Changes() <-chan struct{} {
+	return l.changes
+}
+
+// notify reports a change without blocking.
+func (l *Loader) notify() {
+	select {
+	case l.changes <- struct{}{}:
+	default:
+	}
+}
+
+// updateWatches starts watching the watchers that are new, and stops
+// watching those that are no longer present.
+func (l *Loader) updateWatches(watchers []watcher) {
+	current := make(map[string]bool)
+	for _, w := range watchers {
+		key := w.watchKey()
+		current[key] = true
+		if _, ok := l.watching[key]; ok {
+			continue
+		}
+
+		stop := make(chan struct{})
+		l.watching[key] = stop
+		go w.watch(l.notify, stop)
+	}
+
+	for key, stop := range l.watching {
+		if !current[key] {
+			close(stop)
+			delete(l.watching, key)
+		}
+	}
+}
+
+// Load retrieves every source and merges them into a single manifest, which
+// is validated as a whole.  Target names must be unique across sources.
+func (l *Loader) Load() (merged Manifest, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locations, err := expandSources(l.Sources)
+	if err != nil {
+		return
+	}
+
+	cache := make(map[string]cachedSource)
+	var watchers []watcher
+	for _, location := range locations {
+		cached, e := l.loadLocation(location, l.cache[location])
+		if e != nil {
+			err = fmt.Errorf("loading manifest %s: %s", location, e)
+			return
+		}
+		cache[location] = cached
+
+		m, e := cached.doc.resolve()
+		if e != nil {
+			err = fmt.Errorf("loading manifest %s: %s", location, e)
+			return
+		}
+
+		for index, target := range m.Targets {
+			attributes := make(map[string]string)
+			for k, v := range target.Attributes {
+				attributes[k] = v
+			}
+			attributes[SourceAttribute] = location
+			target.Attributes = attributes
+
+			merged.Targets = append(merged.Targets, target)
+			merged.origins = append(merged.origins, origin{
+				source: location,
+				index:  m.origin(index).index,
+			})
+		}
+
+		merged.Maintenance = append(merged.Maintenance, m.Maintenance...)
+
+		for _, problem := range m.problems {
+			problem.Source = location
+			merged.problems = append(merged.problems, problem)
+		}
+		watchers = append(watchers, m.watchers...)
+	}
+	l.cache = cache
+	l.updateWatches(watchers)
+
+	err = merged.prepare(l.DefaultInterval)
+	return
+}
+
+// loadLocation returns what is at location, reusing what was previously
+// loaded from there if it has not changed.
+func (l *Loader) loadLocation(location string, previous cachedSource) (cached cachedSource, err error) {
+	var body []byte
+
+	if strings.HasPrefix(location, "file://") {
+		if body, err = ioutil.ReadFile(location[7:]); err != nil {
+			return
+		}
+		sum := md5.Sum(body)
+		if cached.sum = hex.EncodeToString(sum[:]); cached.sum == previous.sum {
+			return previous, nil
+		}
+	} else {
+		body, cached.validators, err = fetchHTTP(location, previous.validators)
+		if err == ErrNotModified {
+			return previous, nil
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	cached.doc, err = parse(body)
+	return
+}
+
+// expandSources turns file:// directories and glob patterns into the
+// individual files they refer to.  Other sources are returned as they are.
+func expandSources(sources []string) (locations []string, err error) {
+	for _, source := range sources {
+		if !strings.HasPrefix(source, "file://") {
+			locations = append(locations, source)
+			continue
+		}
+
+		pattern := source[7:]
+		if info, e := os.Stat(pattern); e == nil && info.IsDir() {
+			pattern = filepath.Join(pattern, "*.json")
+		} else if !hasMeta(pattern) {
+			locations = append(locations, source)
+			continue
+		}
+
+		matches, e := filepath.Glob(pattern)
+		if e != nil {
+			err = fmt.Errorf("expanding manifest source %s: %s", source, e)
+			return
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			locations = append(locations, "file://"+match)
+		}
+	}
+
+	return
+}
+
+// hasMeta reports whether path contains any of the magic characters
+// recognized by filepath.Match.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
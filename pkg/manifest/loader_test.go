@@ -0,0 +1,169 @@
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeManifest(t *testing.T, path, name string) {
+	data := fmt.Sprintf(`{
+		"targets": [
+			{
+				"url": "http://www.canary.io/%s",
+				"name": "%s"
+			}
+		]
+	}`, name, name)
+
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoaderMergesSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	confd := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(confd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeManifest(t, filepath.Join(confd, "b.json"), "team-b")
+	writeManifest(t, filepath.Join(confd, "a.json"), "team-a")
+	writeManifest(t, filepath.Join(dir, "c.json"), "team-c")
+
+	fullResponses := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullResponses++
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"targets": [{"url": "http://www.github.com", "name": "github"}]}`)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	l := NewLoader([]string{
+		"file://" + confd,
+		"file://" + filepath.Join(dir, "c*.json"),
+		ts.URL,
+	}, 42)
+
+	for i := 0; i < 2; i++ {
+		m, err := l.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []struct{ name, source string }{
+			{"team-a", "file://" + filepath.Join(confd, "a.json")},
+			{"team-b", "file://" + filepath.Join(confd, "b.json")},
+			{"team-c", "file://" + filepath.Join(dir, "c.json")},
+			{"github", ts.URL},
+		}
+		if len(m.Targets) != len(expected) {
+			t.Fatalf("%d targets found, but expected %d", len(m.Targets), len(expected))
+		}
+		for j, e := range expected {
+			target := m.Targets[j]
+			if target.Name != e.name {
+				t.Fatalf("expected target %d to be named %s, got %s", j, e.name, target.Name)
+			}
+			if target.Attributes[SourceAttribute] != e.source {
+				t.Fatalf("expected target %s to have source %s, got %s", e.name, e.source, target.Attributes[SourceAttribute])
+			}
+			if target.Interval != 42 {
+				t.Fatalf("expected target %s to have the default interval, got %d", e.name, target.Interval)
+			}
+		}
+	}
+
+	if fullResponses != 1 {
+		t.Fatalf("expected the unchanged HTTP source to be fetched once, got %d", fullResponses)
+	}
+}
+
+func TestLoaderReloadsReplacedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "manifest.json")
+	writeManifest(t, path, "team-a")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLoader([]string{"file://" + path}, 42)
+	if _, err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// same size and mtime, as after cp -p
+	writeManifest(t, path, "team-b")
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Targets) != 1 || m.Targets[0].Name != "team-b" {
+		t.Fatalf("expected the replaced file to be loaded, got %+v", m.Targets)
+	}
+}
+
+func TestLoaderDuplicateNamesAcrossSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeManifest(t, filepath.Join(dir, "a.json"), "canary")
+	writeManifest(t, filepath.Join(dir, "b.json"), "canary")
+
+	_, err = NewLoader([]string{"file://" + dir}, 42).Load()
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	if len(verr) != 1 {
+		t.Fatalf("expected a single problem, got %s", verr)
+	}
+
+	fe := verr[0]
+	if fe.Source != "file://"+filepath.Join(dir, "b.json") || fe.Path() != "targets[0].name" {
+		t.Fatalf("expected the problem to be at targets[0].name of b.json, got %s", fe)
+	}
+	if !strings.Contains(fe.Message, "a.json") {
+		t.Fatalf("expected '%s' to name the first definition in a.json", fe.Message)
+	}
+}
+
+func TestLoaderSourceError(t *testing.T) {
+	_, err := NewLoader([]string{"file:///does/not/exist.json"}, 42).Load()
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "file:///does/not/exist.json") {
+		t.Fatalf("expected '%s' to name the failing source", err)
+	}
+}
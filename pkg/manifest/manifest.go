@@ -4,19 +4,34 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"os"
+	"fmt"
 
+	"github.com/canaryio/canary/pkg/maintenance"
 	"github.com/canaryio/canary/pkg/sampler"
 )
 
 // Manifest represents configuration data.
+//
+// Defaults and Groups are as they appear in the manifest document; they have
+// already been applied to Targets.  Settings are inherited from the defaults,
+// then the target's group, then the target itself.
 type Manifest struct {
+	Defaults    TargetDefaults
+	Groups      map[string]TargetDefaults
 	Targets     []sampler.Target
+	Maintenance []maintenance.Window
 	StartDelays []float64
 	Hash        string
+
+	origins  []origin        // where each of Targets was defined, if known
+	problems ValidationError // found while parsing, reported by Validate
+	watchers []watcher       // discoverers that report changes as they happen
+}
+
+// origin records where a target was defined.
+type origin struct {
+	source string
+	index  int
 }
 
 // GenerateRampupDelays generates an even distribution of sensor start delays
@@ -38,52 +53,211 @@ func (m *Manifest) setHash() {
 	m.Hash = hex.EncodeToString(hasher.Sum(nil))
 }
 
-// Get retreives a manifest from a given URL.
+// Get retreives a manifest from a given URL. The manifest is validated
+// before it is returned; if it has problems, the error is a ValidationError.
+//
+// Every call retrieves the whole manifest.  A Loader makes conditional
+// requests instead, as it keeps what it last loaded.
 func Get(url string, defaultInterval int) (manifest Manifest, err error) {
-	var stream io.ReadCloser
-
-	if url[:7] == "file://" {
-		stream, err = os.Open(url[7:])
-	} else {
-		resp, e := http.Get(url)
-		err = e
-		if err != nil {
-			return
-		}
-
-		stream = resp.Body
+	body, err := fetch(url)
+	if err != nil {
+		return
 	}
 
-	defer stream.Close()
-
-	body, err := ioutil.ReadAll(stream)
+	doc, err := parse(body)
 	if err != nil {
 		return
 	}
 
-	err = json.Unmarshal(body, &manifest)
+	manifest, err = doc.resolve()
 	if err != nil {
 		return
 	}
 
+	err = manifest.prepare(defaultInterval)
+	return
+}
+
+// document is the JSON representation of a manifest.
+type document struct {
+	Defaults    TargetDefaults
+	Groups      map[string]TargetDefaults
+	Targets     []targetSpec
+	Maintenance []maintenance.Window
+}
+
+// targetSpec is the JSON representation of a target, which may inherit
+// settings from the defaults and a group, and may be a template expanded
+// by its matrix and the instances found by a discoverer.
+type targetSpec struct {
+	TargetDefaults
+	URL        string
+	Name       string
+	Group      string
+	Matrix     Matrix
+	SRV        string
+	Kubernetes *KubernetesSource
+	Consul     *ConsulSource
+}
+
+// parse decodes a manifest document.
+func parse(body []byte) (doc document, err error) {
+	err = json.Unmarshal(body, &doc)
+	return
+}
+
+// resolve builds a manifest from the document, resolving the settings each
+// target inherits and expanding templated targets.  Problems found while
+// doing so, such as a target naming an unknown group, are reported when the
+// manifest is validated.  An error is returned if discovery fails.
+func (doc document) resolve() (manifest Manifest, err error) {
+	manifest.Defaults = doc.Defaults
+	manifest.Groups = doc.Groups
+	manifest.Maintenance = doc.Maintenance
+
+	for i, w := range doc.Maintenance {
+		if e := w.Validate(); e != nil {
+			manifest.problems = append(manifest.problems, FieldError{
+				Index:   -1,
+				Field:   fmt.Sprintf("maintenance[%d]", i),
+				Message: e.Error(),
+			})
+		}
+	}
+
+	for i, spec := range doc.Targets {
+		problem := func(field, format string, args ...interface{}) {
+			manifest.problems = append(manifest.problems, FieldError{
+				Index:   i,
+				Field:   field,
+				Message: fmt.Sprintf(format, args...),
+			})
+		}
+
+		settings := doc.Defaults
+		if spec.Group != "" {
+			group, ok := doc.Groups[spec.Group]
+			if !ok {
+				problem("group", "unknown group %q", spec.Group)
+			}
+			settings = settings.merge(group)
+		}
+		settings = settings.merge(spec.TargetDefaults)
+
+		// keys are the variables used to name expanded targets
+		keys := spec.Matrix.keys()
+		for _, k := range keys {
+			if len(spec.Matrix[k]) == 0 {
+				problem("matrix."+k, "has no values")
+			}
+		}
+		for _, v := range unknownVariables(spec.SRV, keys) {
+			problem("srv", "unknown matrix variable %s", v)
+		}
+
+		instances := []instance{}
+		for _, vars := range spec.Matrix.combinations() {
+			instances = append(instances, instance{vars: vars})
+		}
+
+		ds := spec.discoverers()
+		if len(ds) > 1 {
+			problem("", "only one of srv, kubernetes and consul may be set")
+			continue
+		}
+		if spec.Kubernetes != nil {
+			if _, e := spec.Kubernetes.path(); e != nil {
+				problem("kubernetes.kind", "%s", e)
+				continue
+			}
+		}
+		known := keys
+		if len(ds) == 1 {
+			if spec.URL == "" {
+				spec.URL = ds[0].defaultURL()
+			}
+			known = append(append([]string{}, keys...), ds[0].variables()...)
+			keys = append(keys, ds[0].nameVariables()...)
+		}
+
+		// templates are checked before querying any registry
+		unknown := false
+		for _, field := range []struct{ name, value string }{{"url", spec.URL}, {"name", spec.Name}} {
+			for _, v := range unknownVariables(field.value, known) {
+				problem(field.name, "unknown template variable %s", v)
+				unknown = true
+			}
+		}
+		if unknown {
+			continue
+		}
+
+		if len(ds) == 1 {
+			d := ds[0]
+			if w, ok := d.(watcher); ok && w.watchKey() != "" {
+				manifest.watchers = append(manifest.watchers, w)
+			}
+
+			var discovered []instance
+			for _, inst := range instances {
+				found, e := d.discover(inst.vars)
+				if e != nil {
+					err = fmt.Errorf("targets[%d].%s: %s", i, d.field(), e)
+					return
+				}
+				for _, f := range found {
+					for k, v := range inst.vars {
+						f.vars[k] = v
+					}
+					discovered = append(discovered, f)
+				}
+			}
+			instances = discovered
+		}
+
+		for _, inst := range instances {
+			u, e := sampler.NewJsonURL(expand(spec.URL, inst.vars))
+			if e != nil {
+				problem("url", "%s", e)
+				break
+			}
+
+			target := sampler.Target{
+				URL:  *u,
+				Name: expandName(spec.Name, keys, inst.vars),
+			}
+			settings.merge(inst.settings).apply(&target)
+			target.Attributes = mergeMaps(target.Attributes, inst.attributes)
+			expandTarget(&target, inst.vars, inst.unrecorded)
+
+			manifest.Targets = append(manifest.Targets, target)
+			manifest.origins = append(manifest.origins, origin{index: i})
+		}
+	}
+
+	return
+}
+
+// prepare readies a freshly parsed manifest for use, and validates it.
+func (m *Manifest) prepare(defaultInterval int) error {
 	// Store the MD5 hash of the raw manifest
-	manifest.setHash()
+	m.setHash()
 
 	// Determine whether to use target.Interval or defaultInterval
 	// Targets that lack an interval value in JSON will have their value set to zero. in this case,
 	// use defaultInterval
-	for ind := range manifest.Targets {
-		if manifest.Targets[ind].Interval == 0 {
-			manifest.Targets[ind].Interval = defaultInterval
+	for ind := range m.Targets {
+		if m.Targets[ind].Interval == 0 {
+			m.Targets[ind].Interval = defaultInterval
 		}
-		manifest.Targets[ind].SetHash()
+		m.Targets[ind].SetHash()
 	}
 
-	// Initialize manifest.StartDelays to zeros
-	manifest.StartDelays = make([]float64, len(manifest.Targets))
-	for i := 0; i < len(manifest.Targets); i++ {
-		manifest.StartDelays[i] = 0.0
+	// Initialize StartDelays to zeros
+	m.StartDelays = make([]float64, len(m.Targets))
+	for i := 0; i < len(m.Targets); i++ {
+		m.StartDelays[i] = 0.0
 	}
 
-	return
+	return m.Validate()
 }
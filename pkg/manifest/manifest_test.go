@@ -18,7 +18,7 @@ func TestGetWithoutInterval(t *testing.T) {
 			]
 		}`
 
-		fmt.Fprintf(w, data)
+		fmt.Fprint(w, data)
 	}
 
 	ts := httptest.NewServer(http.HandlerFunc(handler))
@@ -43,7 +43,7 @@ func TestGetWithoutInterval(t *testing.T) {
 	}
 
 	if target.Interval != 42 {
-		t.Fatal("expected Interval to be equal to 42 when undefined in the manifest json, got %d", target.Interval)
+		t.Fatalf("expected Interval to be equal to 42 when undefined in the manifest json, got %d", target.Interval)
 	}
 }
 
@@ -64,7 +64,7 @@ func TestGetWithInterval(t *testing.T) {
 			]
 		}`
 
-		fmt.Fprintf(w, data)
+		fmt.Fprint(w, data)
 	}
 
 	ts := httptest.NewServer(http.HandlerFunc(handler))
@@ -104,7 +104,7 @@ func TestGetWithTags(t *testing.T) {
 			]
 		}`
 
-		fmt.Fprintf(w, data)
+		fmt.Fprint(w, data)
 	}
 
 	ts := httptest.NewServer(http.HandlerFunc(handler))
@@ -155,7 +155,7 @@ func TestGetWithAttributes(t *testing.T) {
 			]
 		}`
 
-		fmt.Fprintf(w, data)
+		fmt.Fprint(w, data)
 	}
 
 	ts := httptest.NewServer(http.HandlerFunc(handler))
@@ -208,7 +208,7 @@ func TestGetWithRequestHeaders(t *testing.T) {
 			]
 		}`
 
-		fmt.Fprintf(w, data)
+		fmt.Fprint(w, data)
 	}
 
 	ts := httptest.NewServer(http.HandlerFunc(handler))
@@ -261,7 +261,7 @@ func TestGetRampup(t *testing.T) {
 			]
 		}`
 
-		fmt.Fprintf(w, data)
+		fmt.Fprint(w, data)
 	}
 
 	ts := httptest.NewServer(http.HandlerFunc(handler))
@@ -280,7 +280,7 @@ func TestGetRampup(t *testing.T) {
 	// without calling GenerateRampupDelays, StartDelays should all be zero.
 	for index, value := range m.StartDelays {
 		if value != 0.0 {
-			t.Fatalf("Expected initial start delay to be 0.0, got %d for index %d", value, index)
+			t.Fatalf("Expected initial start delay to be 0.0, got %f for index %d", value, index)
 		}
 	}
 
@@ -289,18 +289,18 @@ func TestGetRampup(t *testing.T) {
 	m.GenerateRampupDelays(10)
 
 	if m.StartDelays[0] != 0.0 {
-		t.Fatalf("The first start delay should be 0.0 even after generation, got %d", m.StartDelays[0])
+		t.Fatalf("The first start delay should be 0.0 even after generation, got %f", m.StartDelays[0])
 	}
 
 	if m.StartDelays[1] != 2500.0 {
-		t.Fatalf("The second start delay should be 2500.0 ms after generation, got %d", m.StartDelays[1])
+		t.Fatalf("The second start delay should be 2500.0 ms after generation, got %f", m.StartDelays[1])
 	}
 
 	if m.StartDelays[2] != 5000.0 {
-		t.Fatalf("The second start delay should be 5000.0 ms after generation, got %d", m.StartDelays[2])
+		t.Fatalf("The second start delay should be 5000.0 ms after generation, got %f", m.StartDelays[2])
 	}
 
 	if m.StartDelays[3] != 7500.0 {
-		t.Fatalf("The second start delay should be 7500.0 ms after generation, got %d", m.StartDelays[3])
+		t.Fatalf("The second start delay should be 7500.0 ms after generation, got %f", m.StartDelays[3])
 	}
 }
@@ -0,0 +1,75 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srvDiscoverer expands a target into one instance per SRV record of a
+// name, which may use matrix variables.
+type srvDiscoverer string
+
+// SRVTimeout bounds the time taken to resolve a single SRV name.
+var SRVTimeout = 5 * time.Second
+
+// resolver is used for SRV lookups; tests point it at a local DNS stub.
+var resolver = net.DefaultResolver
+
+func (d srvDiscoverer) field() string {
+	return "srv"
+}
+
+func (d srvDiscoverer) variables() []string {
+	return []string{"host", "port", "weight", "priority"}
+}
+
+func (d srvDiscoverer) nameVariables() []string {
+	return []string{"host", "port"}
+}
+
+func (d srvDiscoverer) defaultURL() string {
+	return ""
+}
+
+// discover resolves the SRV records of the name, ordered by host and port.
+// Weights and priorities are not recorded as attributes, so that changing
+// them does not restart the sensors of the instances.
+// A name without records, as when a service is scaled to zero, has no
+// instances; only other resolver errors fail the load.
+func (d srvDiscoverer) discover(vars map[string]string) ([]instance, error) {
+	name := expand(string(d), vars)
+	ctx, cancel := context.WithTimeout(context.Background(), SRVTimeout)
+	defer cancel()
+
+	_, records, err := resolver.LookupSRV(ctx, "", "", name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %s", name, err)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Target != records[j].Target {
+			return records[i].Target < records[j].Target
+		}
+		return records[i].Port < records[j].Port
+	})
+
+	instances := make([]instance, len(records))
+	for i, r := range records {
+		instances[i].vars = map[string]string{
+			"host":     strings.TrimSuffix(r.Target, "."),
+			"port":     strconv.Itoa(int(r.Port)),
+			"weight":   strconv.Itoa(int(r.Weight)),
+			"priority": strconv.Itoa(int(r.Priority)),
+		}
+		instances[i].unrecorded = []string{"weight", "priority"}
+	}
+	return instances, nil
+}
@@ -0,0 +1,261 @@
+package manifest
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type srvRecord struct {
+	priority, weight, port uint16
+	target                 string
+}
+
+// dnsStub is a minimal DNS server answering SRV queries from a fixed table.
+// Names it does not know are answered with NXDOMAIN, and failing names with
+// SERVFAIL.
+type dnsStub struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	records map[string][]srvRecord
+	failing map[string]bool
+}
+
+func newDNSStub(t *testing.T) *dnsStub {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &dnsStub{conn: conn, records: make(map[string][]srvRecord), failing: make(map[string]bool)}
+	go s.serve()
+	return s
+}
+
+func (s *dnsStub) set(name string, records ...srvRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[name] = records
+}
+
+// resolver returns a resolver that sends every query to the stub.
+func (s *dnsStub) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *dnsStub) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.answer(buf[:n]); resp != nil {
+			s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *dnsStub) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	// read the name of the first question
+	var labels []string
+	offset := 12
+	for offset < len(query) && query[offset] != 0 {
+		l := int(query[offset])
+		labels = append(labels, string(query[offset+1:offset+1+l]))
+		offset += 1 + l
+	}
+	questionEnd := offset + 5 // terminating zero, type and class
+	if questionEnd > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	s.mu.Lock()
+	records, ok := s.records[name]
+	failing := s.failing[name]
+	s.mu.Unlock()
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])
+	flags := uint16(0x8180)
+	switch {
+	case failing:
+		flags |= 2 // SERVFAIL
+	case !ok:
+		flags |= 3 // NXDOMAIN
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(records)))
+	resp = append(resp, query[12:questionEnd]...)
+
+	for _, r := range records {
+		var target []byte
+		for _, label := range strings.Split(strings.TrimSuffix(r.target, "."), ".") {
+			target = append(target, byte(len(label)))
+			target = append(target, label...)
+		}
+		target = append(target, 0)
+
+		rr := make([]byte, 16)
+		binary.BigEndian.PutUint16(rr[0:], 0xc00c) // pointer to the question name
+		binary.BigEndian.PutUint16(rr[2:], 33)     // SRV
+		binary.BigEndian.PutUint16(rr[4:], 1)      // IN
+		binary.BigEndian.PutUint32(rr[6:], 60)     // TTL
+		binary.BigEndian.PutUint16(rr[10:], uint16(6+len(target)))
+		binary.BigEndian.PutUint16(rr[12:], r.priority)
+		binary.BigEndian.PutUint16(rr[14:], r.weight)
+		rr = append(rr, 0, 0)
+		binary.BigEndian.PutUint16(rr[16:], r.port)
+		resp = append(resp, rr...)
+		resp = append(resp, target...)
+	}
+
+	return resp
+}
+
+func TestLoaderWithSRV(t *testing.T) {
+	stub := newDNSStub(t)
+	defer stub.conn.Close()
+
+	previous := resolver
+	resolver = stub.resolver()
+	defer func() { resolver = previous }()
+
+	stub.set("_http._tcp.api.canary.test",
+		srvRecord{10, 60, 8080, "b.canary.test."},
+		srvRecord{10, 40, 8081, "a.canary.test."},
+	)
+
+	data := `{
+		"targets": [
+			{
+				"url": "http://{host}:{port}/health",
+				"name": "api",
+				"srv": "_http._tcp.api.canary.test"
+			}
+		]
+	}`
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	l := NewLoader([]string{ts.URL}, 42)
+	m, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Targets) != 2 {
+		t.Fatalf("%d targets found, but expected 2", len(m.Targets))
+	}
+
+	first := m.Targets[0]
+	if first.Name != "api-a.canary.test-8081" {
+		t.Fatalf("expected the first target to be named api-a.canary.test-8081, got %s", first.Name)
+	}
+	if first.URL.String() != "http://a.canary.test:8081/health" {
+		t.Fatalf("expected the first target URL to be http://a.canary.test:8081/health, got %s", first.URL)
+	}
+	if first.Attributes["host"] != "a.canary.test" || first.Attributes["port"] != "8081" || first.Attributes["weight"] != "" {
+		t.Fatalf("expected the first target to carry its host and port as attributes, got %v", first.Attributes)
+	}
+	unchanged := m.Targets[1].Hash
+
+	// instances coming and going are noticed on the next load, even though
+	// the manifest itself has not changed, and weight changes are not
+	stub.set("_http._tcp.api.canary.test",
+		srvRecord{20, 30, 8080, "b.canary.test."},
+		srvRecord{10, 40, 8082, "c.canary.test."},
+	)
+
+	reloaded, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.Hash == m.Hash {
+		t.Fatal("expected the manifest hash to change with the SRV records")
+	}
+	if reloaded.Targets[0].Hash != unchanged {
+		t.Fatal("expected the hash of the unchanged instance to be kept")
+	}
+	if reloaded.Targets[1].Name != "api-c.canary.test-8082" {
+		t.Fatalf("expected the new instance to be named api-c.canary.test-8082, got %s", reloaded.Targets[1].Name)
+	}
+}
+
+func TestLoaderWithMissingSRV(t *testing.T) {
+	stub := newDNSStub(t)
+	defer stub.conn.Close()
+	stub.set("_http._tcp.empty.canary.test")
+
+	previous := resolver
+	resolver = stub.resolver()
+	defer func() { resolver = previous }()
+
+	// a name that does not exist, and one without records
+	data := `{
+		"targets": [
+			{"url": "http://www.canary.io", "name": "www"},
+			{"url": "http://{host}:{port}/", "name": "api", "srv": "_http._tcp.missing.canary.test"},
+			{"url": "http://{host}:{port}/", "name": "web", "srv": "_http._tcp.empty.canary.test"}
+		]
+	}`
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	m, err := NewLoader([]string{ts.URL}, 42).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Targets) != 1 || m.Targets[0].Name != "www" {
+		t.Fatalf("expected missing SRV records to yield no targets, got %+v", m.Targets)
+	}
+}
+
+func TestLoaderWithFailedSRV(t *testing.T) {
+	stub := newDNSStub(t)
+	defer stub.conn.Close()
+	stub.mu.Lock()
+	stub.failing["_http._tcp.broken.canary.test"] = true
+	stub.mu.Unlock()
+
+	previous := resolver
+	resolver = stub.resolver()
+	defer func() { resolver = previous }()
+
+	data := `{
+		"targets": [
+			{
+				"url": "http://{host}:{port}/",
+				"name": "api",
+				"srv": "_http._tcp.broken.canary.test"
+			}
+		]
+	}`
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	_, err := NewLoader([]string{ts.URL}, 42).Load()
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "targets[0].srv") {
+		t.Fatalf("expected '%s' to name targets[0].srv", err)
+	}
+}
@@ -0,0 +1,133 @@
+package manifest
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+// placeholder matches a {variable} in a templated target.
+var placeholder = regexp.MustCompile(`\{[A-Za-z0-9_.-]+\}`)
+
+// Matrix maps template variables to the values they take.  A target with a
+// matrix is expanded into one target for every combination of values.
+type Matrix map[string][]string
+
+// keys returns the variables of the matrix in sorted order.
+func (mx Matrix) keys() []string {
+	keys := make([]string, 0, len(mx))
+	for k := range mx {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// combinations returns every combination of the matrix values, varying the
+// last variable fastest.  An empty matrix has a single, empty combination.
+func (mx Matrix) combinations() []map[string]string {
+	combos := []map[string]string{{}}
+	for _, k := range mx.keys() {
+		var next []map[string]string
+		for _, combo := range combos {
+			for _, v := range mx[k] {
+				c := make(map[string]string, len(combo)+1)
+				for ck, cv := range combo {
+					c[ck] = cv
+				}
+				c[k] = v
+				next = append(next, c)
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+// expand replaces each {variable} in s with its value from vars.
+func expand(s string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return s
+	}
+	return placeholder.ReplaceAllStringFunc(s, func(p string) string {
+		if v, ok := vars[p[1:len(p)-1]]; ok {
+			return v
+		}
+		return p
+	})
+}
+
+// unknownVariables returns the placeholders in s that are not in known.
+// Known variables ending in ".*" match any variable with that prefix.
+func unknownVariables(s string, known []string) (unknown []string) {
+	for _, p := range placeholder.FindAllString(s, -1) {
+		name := p[1 : len(p)-1]
+		found := false
+		for _, k := range known {
+			if name == k || strings.HasSuffix(k, ".*") && strings.HasPrefix(name, k[:len(k)-1]) {
+				found = true
+			}
+		}
+		if !found {
+			unknown = append(unknown, p)
+		}
+	}
+	return
+}
+
+// expandName generates the name of an expanded target.  Names with
+// placeholders are expanded; otherwise the values of keys are appended
+// to the name in order, e.g. "api-us-prod".
+func expandName(name string, keys []string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return name
+	}
+	if placeholder.MatchString(name) {
+		return expand(name, vars)
+	}
+
+	parts := []string{name}
+	for _, k := range keys {
+		parts = append(parts, vars[k])
+	}
+	return strings.Join(parts, "-")
+}
+
+// expandTarget applies vars to every templated field of t, and records them
+// as attributes so that publishers can slice metrics by them, except for
+// those unrecorded.
+func expandTarget(t *sampler.Target, vars map[string]string, unrecorded []string) {
+	if len(vars) == 0 {
+		return
+	}
+
+	tags := make([]string, len(t.Tags))
+	for i, tag := range t.Tags {
+		tags[i] = expand(tag, vars)
+	}
+	t.Tags = tags
+
+	headers := make(map[string]string, len(t.RequestHeaders))
+	for k, v := range t.RequestHeaders {
+		headers[k] = expand(v, vars)
+	}
+	t.RequestHeaders = headers
+
+	attributes := make(map[string]string, len(t.Attributes)+len(vars))
+	for k, v := range t.Attributes {
+		attributes[k] = expand(v, vars)
+	}
+	recorded := make(map[string]string, len(vars))
+	for k, v := range vars {
+		recorded[k] = v
+	}
+	for _, k := range unrecorded {
+		delete(recorded, k)
+	}
+	for k, v := range recorded {
+		attributes[k] = v
+	}
+	t.Attributes = attributes
+}
@@ -0,0 +1,95 @@
+package manifest
+
+import (
+	"testing"
+)
+
+func TestGetWithMatrix(t *testing.T) {
+	data := `{
+		"groups": {
+			"regional": {"requestHeaders": {"X-Region": "{region}"}}
+		},
+		"targets": [
+			{
+				"url": "https://{region}.{env}.example.com/health",
+				"name": "health",
+				"group": "regional",
+				"tags": ["{env}"],
+				"matrix": {
+					"region": ["us", "eu", "ap"],
+					"env": ["staging", "prod"]
+				}
+			},
+			{
+				"url": "https://{region}.example.com/",
+				"name": "home-{region}",
+				"matrix": {"region": ["us"]}
+			}
+		]
+	}`
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	m, err := Get(ts.URL, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct{ name, url, region, env string }{
+		{"health-staging-us", "https://us.staging.example.com/health", "us", "staging"},
+		{"health-staging-eu", "https://eu.staging.example.com/health", "eu", "staging"},
+		{"health-staging-ap", "https://ap.staging.example.com/health", "ap", "staging"},
+		{"health-prod-us", "https://us.prod.example.com/health", "us", "prod"},
+		{"health-prod-eu", "https://eu.prod.example.com/health", "eu", "prod"},
+		{"health-prod-ap", "https://ap.prod.example.com/health", "ap", "prod"},
+		{"home-us", "https://us.example.com/", "us", ""},
+	}
+	if len(m.Targets) != len(expected) {
+		t.Fatalf("%d targets found, but expected %d", len(m.Targets), len(expected))
+	}
+
+	for i, e := range expected {
+		target := m.Targets[i]
+		if target.Name != e.name {
+			t.Errorf("expected target %d to be named %s, got %s", i, e.name, target.Name)
+		}
+		if target.URL.String() != e.url {
+			t.Errorf("expected target %s to have URL %s, got %s", e.name, e.url, target.URL)
+		}
+		if target.Attributes["region"] != e.region || target.Attributes["env"] != e.env {
+			t.Errorf("expected target %s to have region %q and env %q attributes, got %v", e.name, e.region, e.env, target.Attributes)
+		}
+	}
+
+	first := m.Targets[0]
+	if first.RequestHeaders["X-Region"] != "us" {
+		t.Errorf("expected inherited headers to be expanded, got %v", first.RequestHeaders)
+	}
+	if len(first.Tags) != 1 || first.Tags[0] != "staging" {
+		t.Errorf("expected tags to be expanded, got %v", first.Tags)
+	}
+}
+
+func TestGetWithInvalidMatrix(t *testing.T) {
+	data := `{
+		"targets": [
+			{
+				"url": "https://{region}.{zone}.example.com/",
+				"name": "health",
+				"matrix": {"region": ["us"], "env": []}
+			}
+		]
+	}`
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	_, err := Get(ts.URL, 42)
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	if len(verr) != 2 || verr[0].Path() != "targets[0].matrix.env" || verr[1].Path() != "targets[0].url" {
+		t.Fatalf("expected problems at targets[0].matrix.env and targets[0].url, got %s", verr)
+	}
+}
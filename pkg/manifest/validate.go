@@ -0,0 +1,132 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError describes a single problem found in a manifest.
+type FieldError struct {
+	Source  string // manifest the offending target was loaded from, if known
+	Index   int    // index of the offending target, or -1 for the manifest itself
+	Field   string // name of the offending field, e.g. "url"
+	Message string
+}
+
+// Path returns the location of the problem within the manifest,
+// e.g. "targets[3].url".
+func (e FieldError) Path() string {
+	path := ""
+	if e.Index >= 0 {
+		path = fmt.Sprintf("targets[%d]", e.Index)
+	}
+	if e.Field != "" {
+		if path != "" {
+			path += "."
+		}
+		path += e.Field
+	}
+	return path
+}
+
+func (e FieldError) Error() string {
+	if e.Source != "" {
+		return fmt.Sprintf("%s: %s: %s", e.Source, e.Path(), e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path(), e.Message)
+}
+
+// ValidationError is the collection of every problem found in a manifest.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return fmt.Sprintf("invalid manifest: %s", strings.Join(msgs, "; "))
+}
+
+// Validate checks the manifest for problems that would otherwise only
+// surface once sensors are running. Every problem is collected, and
+// returned together as a ValidationError. A valid manifest returns nil.
+func (m *Manifest) Validate() error {
+	errs := append(ValidationError(nil), m.problems...)
+	add := func(i int, field, format string, args ...interface{}) {
+		o := m.origin(i)
+		errs = append(errs, FieldError{
+			Source:  o.source,
+			Index:   o.index,
+			Field:   field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	names := make(map[string]int)
+	for i, t := range m.Targets {
+		if t.Name == "" {
+			add(i, "name", "is required")
+		} else if first, ok := names[t.Name]; ok {
+			o := m.origin(first)
+			if o.source != "" {
+				add(i, "name", "duplicate name %q, first defined by targets[%d] in %s", t.Name, o.index, o.source)
+			} else {
+				add(i, "name", "duplicate name %q, first defined by targets[%d]", t.Name, o.index)
+			}
+		} else {
+			names[t.Name] = i
+		}
+
+		if t.URL.URL == nil || t.URL.String() == "" {
+			add(i, "url", "is required")
+		} else {
+			switch t.URL.Scheme {
+			case "http", "https":
+				if t.URL.Host == "" {
+					add(i, "url", "%q has no host", t.URL.String())
+				}
+			default:
+				add(i, "url", "scheme %q is not supported, must be http or https", t.URL.Scheme)
+			}
+		}
+
+		if t.Interval < 0 {
+			add(i, "interval", "must not be negative, got %d", t.Interval)
+		}
+
+		if t.ExpectedStatus != 0 && (t.ExpectedStatus < 100 || t.ExpectedStatus > 599) {
+			add(i, "expectedStatus", "%d is not a valid HTTP status", t.ExpectedStatus)
+		}
+
+		for _, f := range []struct {
+			name  string
+			value int
+		}{
+			{"confirmFailures", t.ConfirmFailures},
+			{"confirmRecoveries", t.ConfirmRecoveries},
+			{"flapWindow", t.FlapWindow},
+			{"flapThreshold", t.FlapThreshold},
+		} {
+			if f.value < 0 {
+				add(i, f.name, "must not be negative, got %d", f.value)
+			}
+		}
+		if t.FlapThreshold > 0 && t.FlapWindow == 0 {
+			add(i, "flapWindow", "is required with flapThreshold")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// origin returns where the target at index i was defined.  Targets without
+// a recorded origin are identified by their position in the manifest.
+func (m *Manifest) origin(i int) origin {
+	if i < len(m.origins) {
+		return m.origins[i]
+	}
+	return origin{index: i}
+}
@@ -0,0 +1,152 @@
+package manifest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+func parseURL(str string) sampler.JsonURL {
+	u, _ := sampler.NewJsonURL(str)
+	return *u
+}
+
+func TestValidateOK(t *testing.T) {
+	m := Manifest{
+		Targets: []sampler.Target{
+			{URL: parseURL("http://www.canary.io"), Name: "canary", Interval: 1},
+			{URL: parseURL("https://www.github.com"), Name: "github"},
+		},
+	}
+
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected manifest to be valid, got %s", err)
+	}
+}
+
+func TestValidateCollectsEveryProblem(t *testing.T) {
+	m := Manifest{
+		Targets: []sampler.Target{
+			{URL: parseURL("http://www.canary.io"), Name: "canary"},
+			{URL: parseURL("ftp://ftp.canary.io"), Name: "canary", Interval: -1},
+			{Name: ""},
+		},
+	}
+
+	err := m.Validate()
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	expected := []string{
+		"targets[1].name",
+		"targets[1].url",
+		"targets[1].interval",
+		"targets[2].name",
+		"targets[2].url",
+	}
+	if len(verr) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %s", len(expected), len(verr), verr)
+	}
+	for i, path := range expected {
+		if verr[i].Path() != path {
+			t.Errorf("expected problem %d to be at %s, got %s", i, path, verr[i].Path())
+		}
+	}
+}
+
+func TestValidateStabilization(t *testing.T) {
+	m := Manifest{
+		Targets: []sampler.Target{
+			{URL: parseURL("http://www.canary.io"), Name: "canary", ConfirmFailures: 3, FlapWindow: 600, FlapThreshold: 4},
+			{URL: parseURL("http://api.canary.io"), Name: "api", ConfirmRecoveries: -1, FlapThreshold: 4},
+		},
+	}
+
+	err := m.Validate()
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	expected := []string{
+		"targets[1].confirmRecoveries",
+		"targets[1].flapWindow",
+	}
+	if len(verr) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %s", len(expected), len(verr), verr)
+	}
+	for i, path := range expected {
+		if verr[i].Path() != path {
+			t.Errorf("expected problem %d to be at %s, got %s", i, path, verr[i].Path())
+		}
+	}
+}
+
+func TestGetRejectsInvalidManifest(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		data := `{
+			"targets": [
+				{
+					"url": "",
+					"name": "canary"
+				}
+			]
+		}`
+
+		fmt.Fprint(w, data)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	_, err := Get(ts.URL, 42)
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	if len(verr) != 1 || verr[0].Path() != "targets[0].url" {
+		t.Fatalf("expected a single problem at targets[0].url, got %s", verr)
+	}
+}
+
+func TestGetWithMaintenance(t *testing.T) {
+	data := `{
+		"targets": [{"url": "http://www.canary.io", "name": "canary", "tags": ["www"]}],
+		"maintenance": [
+			{"name": "deploys", "tags": ["www"], "schedule": "0 2 * * 2", "duration": 3600},
+			{"name": "migration", "targets": ["canary"], "start": "2026-10-20T10:00:00Z", "end": "2026-10-20T12:00:00Z"}
+		]
+	}`
+	ts := serveManifest(&data)
+	defer ts.Close()
+
+	m, err := Get(ts.URL, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Maintenance) != 2 || m.Maintenance[0].Name != "deploys" || m.Maintenance[1].End == nil {
+		t.Fatalf("expected both maintenance windows, got %+v", m.Maintenance)
+	}
+
+	data = `{
+		"targets": [{"url": "http://www.canary.io", "name": "canary"}],
+		"maintenance": [
+			{"tags": ["www"], "schedule": "0 2 * * 2", "duration": 3600},
+			{"tags": ["www"], "schedule": "0 2 * * 2"}
+		]
+	}`
+	_, err = Get(ts.URL, 42)
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	if len(verr) != 1 || verr[0].Path() != "maintenance[1]" {
+		t.Fatalf("expected a problem at maintenance[1], got %s", verr)
+	}
+}
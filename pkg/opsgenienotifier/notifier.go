@@ -0,0 +1,134 @@
+package opsgenienotifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/canaryio/canary/pkg/alert"
+)
+
+const (
+	// DefaultURL is the base URL of the Opsgenie API.  Accounts in the EU
+	// use https://api.eu.opsgenie.com.
+	DefaultURL = "https://api.opsgenie.com"
+
+	// DefaultPriority is the priority of alerts if none is configured.
+	DefaultPriority = "P1"
+
+	source = "canary"
+
+	requestTimeout = 10 * time.Second
+)
+
+// Settings configure a Notifier, as the settings of an opsgenie receiver.
+type Settings struct {
+	APIKey   string   `json:"api_key"`  // key of an API integration
+	Priority string   `json:"priority"` // P1 to P5
+	Tags     []string `json:"tags"`     // added to the target's tags
+	URL      string   `json:"url"`      // defaults to DefaultURL
+}
+
+// Notifier implements the alert.Notifier interface, and creates an
+// Opsgenie alert when an alert fires, closing it when the alert is
+// resolved.  The alert's fingerprint is the Opsgenie alias, so that
+// repeated notifications are deduplicated.
+type Notifier struct {
+	Settings
+	client *http.Client
+}
+
+// New returns a pointer to a new Notifier.
+func New(settings Settings) (*Notifier, error) {
+	if settings.APIKey == "" {
+		return nil, fmt.Errorf("opsgenie: api_key must be set")
+	}
+	if settings.Priority == "" {
+		settings.Priority = DefaultPriority
+	}
+	switch settings.Priority {
+	case "P1", "P2", "P3", "P4", "P5":
+	default:
+		return nil, fmt.Errorf("opsgenie: unknown priority %q, must be P1 to P5", settings.Priority)
+	}
+	if settings.URL == "" {
+		settings.URL = DefaultURL
+	}
+	settings.URL = strings.TrimSuffix(settings.URL, "/")
+	return &Notifier{settings, &http.Client{Timeout: requestTimeout}}, nil
+}
+
+// NewFromSettings is a convenience func that wraps New, and parses its
+// settings from the JSON object of a receiver.
+func NewFromSettings(raw json.RawMessage) (*Notifier, error) {
+	var s Settings
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("opsgenie: %s", err)
+	}
+	return New(s)
+}
+
+type createRequest struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+	Entity      string            `json:"entity"`
+	Source      string            `json:"source"`
+	Priority    string            `json:"priority"`
+}
+
+type closeRequest struct {
+	Source string `json:"source"`
+	Note   string `json:"note"`
+}
+
+// Notify creates or closes the Opsgenie alert of the alert.
+func (n *Notifier) Notify(a alert.Alert) error {
+	header := http.Header{"Authorization": {"GenieKey " + n.APIKey}}
+
+	var endpoint string
+	var body interface{}
+	if a.Status == alert.Firing {
+		endpoint = n.URL + "/v2/alerts"
+
+		u := ""
+		if a.Target.URL.URL != nil {
+			u = a.Target.URL.String()
+		}
+		r := createRequest{
+			// the API limits messages to 130 characters
+			Message:     alert.Truncate(a.Summary, 130),
+			Alias:       a.Fingerprint,
+			Description: a.Summary,
+			Tags:        append(append([]string(nil), a.Target.Tags...), n.Tags...),
+			Details: map[string]string{
+				"rule":   a.Rule,
+				"target": a.Target.Name,
+				"url":    u,
+			},
+			Entity:   a.Target.Name,
+			Source:   source,
+			Priority: n.Priority,
+		}
+		if a.Measurement.Error != nil {
+			r.Details["error"] = a.Measurement.Error.Error()
+		}
+		for k, v := range a.Target.Attributes {
+			r.Details["attr."+k] = v
+		}
+		body = r
+	} else {
+		endpoint = n.URL + "/v2/alerts/" + url.PathEscape(a.Fingerprint) + "/close?identifierType=alias"
+		body = closeRequest{Source: source, Note: a.Summary}
+	}
+
+	if err := alert.PostJSON(n.client, endpoint, header, body); err != nil {
+		return fmt.Errorf("opsgenie: %s", err)
+	}
+	return nil
+}
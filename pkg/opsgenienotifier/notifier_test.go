@@ -0,0 +1,68 @@
+package opsgenienotifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/alert"
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+func TestNotify(t *testing.T) {
+	type request struct {
+		path, auth string
+		body       map[string]interface{}
+	}
+	var requests []request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, request{r.URL.RequestURI(), r.Header.Get("Authorization"), body})
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	n, err := NewFromSettings(json.RawMessage(`{"api_key": "key", "priority": "P2", "tags": ["canary"], "url": "` + ts.URL + `"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := sampler.NewJsonURL("https://www.canary.io")
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	a := alert.Alert{
+		Rule:        "down",
+		Status:      alert.Firing,
+		Target:      sampler.Target{URL: *u, Name: "www", Tags: []string{"prod"}},
+		Summary:     "www has been down for 3 consecutive samples",
+		StartsAt:    t1,
+		Fingerprint: "0123abcd",
+	}
+	if err := n.Notify(a); err != nil {
+		t.Fatal(err)
+	}
+	a.Status, a.Summary = alert.Resolved, "www is up"
+	if err := n.Notify(a); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	create, closeReq := requests[0], requests[1]
+	if create.path != "/v2/alerts" || create.auth != "GenieKey key" {
+		t.Errorf("expected an authenticated create request, got %s with %q", create.path, create.auth)
+	}
+	b := create.body
+	if b["alias"] != "0123abcd" || b["priority"] != "P2" || b["entity"] != "www" || b["message"] != "www has been down for 3 consecutive samples" {
+		t.Errorf("unexpected create request: %v", b)
+	}
+	if tags, _ := json.Marshal(b["tags"]); string(tags) != `["prod","canary"]` {
+		t.Errorf("expected the target's and configured tags, got %s", tags)
+	}
+	if closeReq.path != "/v2/alerts/0123abcd/close?identifierType=alias" || closeReq.body["note"] != "www is up" {
+		t.Errorf("unexpected close request: %s %v", closeReq.path, closeReq.body)
+	}
+}
@@ -0,0 +1,240 @@
+package otlppublisher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// message is an OTLP protobuf message, built field by field so that it can
+// be encoded both as protobuf and as OTLP JSON without generated code.
+// Fields are encoded in order, and fields with zero values are omitted
+// unless they are wrapped in set.
+type message []field
+
+type field struct {
+	num  int    // protobuf field number
+	name string // JSON field name
+	val  interface{}
+}
+
+// Value types with an encoding of their own; string, bool, float64 (double),
+// int64 (varint), message and []message are used as they are.
+type (
+	fixed64  uint64    // fixed64, such as a timestamp in nanoseconds
+	sfixed64 int64     // sfixed64
+	enum     int       // enum, encoded as its number
+	id       []byte    // trace and span IDs, hex encoded in JSON
+	counts   []uint64  // packed repeated fixed64
+	bounds   []float64 // packed repeated double
+
+	// set is a value that is encoded even if it is zero, such as a member
+	// of a oneof.
+	set struct{ val interface{} }
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// marshalProto encodes m in the protobuf wire format.
+func (m message) marshalProto() []byte {
+	var b []byte
+	for _, f := range m {
+		if !f.zero() {
+			b = f.appendProto(b)
+		}
+	}
+	return b
+}
+
+func (f field) appendProto(b []byte) []byte {
+	tag := func(wire int) {
+		b = binary.AppendUvarint(b, uint64(f.num)<<3|uint64(wire))
+	}
+	bytesField := func(p []byte) {
+		tag(wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(p)))
+		b = append(b, p...)
+	}
+
+	switch v := f.val.(type) {
+	case set:
+		return field{f.num, f.name, v.val}.appendProto(b)
+	case string:
+		bytesField([]byte(v))
+	case bool:
+		tag(wireVarint)
+		if v {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	case int64:
+		tag(wireVarint)
+		b = binary.AppendUvarint(b, uint64(v))
+	case enum:
+		tag(wireVarint)
+		b = binary.AppendUvarint(b, uint64(v))
+	case float64:
+		tag(wireFixed64)
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	case fixed64:
+		tag(wireFixed64)
+		b = binary.LittleEndian.AppendUint64(b, uint64(v))
+	case sfixed64:
+		tag(wireFixed64)
+		b = binary.LittleEndian.AppendUint64(b, uint64(v))
+	case id:
+		bytesField(v)
+	case counts:
+		var p []byte
+		for _, c := range v {
+			p = binary.LittleEndian.AppendUint64(p, c)
+		}
+		bytesField(p)
+	case bounds:
+		var p []byte
+		for _, c := range v {
+			p = binary.LittleEndian.AppendUint64(p, math.Float64bits(c))
+		}
+		bytesField(p)
+	case message:
+		bytesField(v.marshalProto())
+	case []message:
+		for _, m := range v {
+			bytesField(m.marshalProto())
+		}
+	default:
+		panic("otlppublisher: unsupported field type")
+	}
+	return b
+}
+
+// zero reports whether f has the zero value of its type, and is omitted.
+func (f field) zero() bool {
+	switch v := f.val.(type) {
+	case set:
+		return false
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case int64:
+		return v == 0
+	case enum:
+		return v == 0
+	case float64:
+		return v == 0
+	case fixed64:
+		return v == 0
+	case sfixed64:
+		return v == 0
+	case id:
+		return len(v) == 0
+	case counts:
+		return len(v) == 0
+	case bounds:
+		return len(v) == 0
+	case []message:
+		return len(v) == 0
+	}
+	// an empty message still marks which oneof is set
+	return false
+}
+
+// marshalJSON encodes m as OTLP JSON, which differs from the standard
+// protobuf JSON mapping in encoding trace and span IDs as hex.
+func (m message) marshalJSON() []byte {
+	var b bytes.Buffer
+	m.writeJSON(&b)
+	return b.Bytes()
+}
+
+func (m message) writeJSON(b *bytes.Buffer) {
+	b.WriteByte('{')
+	first := true
+	for _, f := range m {
+		if f.zero() {
+			continue
+		}
+		if !first {
+			b.WriteByte(',')
+		}
+		first = false
+		writeString(b, f.name)
+		b.WriteByte(':')
+		f.writeJSON(b)
+	}
+	b.WriteByte('}')
+}
+
+// writeJSON writes the value of f.
+func (f field) writeJSON(b *bytes.Buffer) {
+	switch v := f.val.(type) {
+	case set:
+		field{f.num, f.name, v.val}.writeJSON(b)
+	case string:
+		writeString(b, v)
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case int64:
+		// 64 bit integers are strings in JSON
+		writeString(b, strconv.FormatInt(v, 10))
+	case enum:
+		b.WriteString(strconv.Itoa(int(v)))
+	case float64:
+		writeNumber(b, v)
+	case fixed64:
+		writeString(b, strconv.FormatUint(uint64(v), 10))
+	case sfixed64:
+		writeString(b, strconv.FormatInt(int64(v), 10))
+	case id:
+		writeString(b, hex.EncodeToString(v))
+	case counts:
+		b.WriteByte('[')
+		for i, c := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeString(b, strconv.FormatUint(c, 10))
+		}
+		b.WriteByte(']')
+	case bounds:
+		b.WriteByte('[')
+		for i, c := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeNumber(b, c)
+		}
+		b.WriteByte(']')
+	case message:
+		v.writeJSON(b)
+	case []message:
+		b.WriteByte('[')
+		for i, m := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			m.writeJSON(b)
+		}
+		b.WriteByte(']')
+	default:
+		panic("otlppublisher: unsupported field type")
+	}
+}
+
+func writeNumber(b *bytes.Buffer, v float64) {
+	b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	j, _ := json.Marshal(s)
+	b.Write(j)
+}
@@ -0,0 +1,261 @@
+package otlppublisher
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+// The OTLP messages built by this file, by the names of their fields in
+// opentelemetry/proto.
+
+const (
+	temporalityDelta = enum(1)
+
+	spanKindInternal = enum(1)
+	spanKindClient   = enum(3)
+
+	statusError = enum(2)
+)
+
+const scopeName = "github.com/canaryio/canary"
+
+func keyValue(key string, value interface{}) message {
+	var anyValue message
+	switch v := value.(type) {
+	case string:
+		anyValue = message{{1, "stringValue", set{v}}}
+	case bool:
+		anyValue = message{{2, "boolValue", set{v}}}
+	case int64:
+		anyValue = message{{3, "intValue", set{v}}}
+	case float64:
+		anyValue = message{{4, "doubleValue", set{v}}}
+	}
+	return message{{1, "key", key}, {2, "value", anyValue}}
+}
+
+// targetAttributes describes a target: its name, URL and tags, and a
+// canary.attr.<key> attribute for each of its attributes.
+func targetAttributes(t sampler.Target) []message {
+	tags := append([]string(nil), t.Tags...)
+	sort.Strings(tags)
+
+	attrs := []message{
+		keyValue("canary.target.name", t.Name),
+		keyValue("url.full", t.URL.String()),
+	}
+	if len(tags) > 0 {
+		attrs = append(attrs, keyValue("canary.target.tags", strings.Join(tags, ",")))
+	}
+
+	keys := make([]string, 0, len(t.Attributes))
+	for k := range t.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, keyValue("canary.attr."+k, t.Attributes[k]))
+	}
+	return attrs
+}
+
+func resource(serviceName string) message {
+	return message{{1, "attributes", []message{keyValue("service.name", serviceName)}}}
+}
+
+func scope() message {
+	return message{{1, "name", scopeName}}
+}
+
+// metricsRequest wraps metrics in an ExportMetricsServiceRequest.
+func metricsRequest(serviceName string, metrics []message) message {
+	return message{{1, "resourceMetrics", []message{{
+		{1, "resource", resource(serviceName)},
+		{2, "scopeMetrics", []message{{
+			{1, "scope", scope()},
+			{2, "metrics", metrics},
+		}}},
+	}}}}
+}
+
+// tracesRequest wraps spans in an ExportTraceServiceRequest.
+func tracesRequest(serviceName string, spans []message) message {
+	return message{{1, "resourceSpans", []message{{
+		{1, "resource", resource(serviceName)},
+		{2, "scopeSpans", []message{{
+			{1, "scope", scope()},
+			{2, "spans", spans},
+		}}},
+	}}}}
+}
+
+func nanos(t time.Time) fixed64 {
+	return fixed64(t.UnixNano())
+}
+
+func gauge(name, description, unit string, points []message) message {
+	return message{
+		{1, "name", name},
+		{2, "description", description},
+		{3, "unit", unit},
+		{5, "gauge", message{{1, "dataPoints", points}}},
+	}
+}
+
+func deltaSum(name, description, unit string, points []message) message {
+	return message{
+		{1, "name", name},
+		{2, "description", description},
+		{3, "unit", unit},
+		{7, "sum", message{
+			{1, "dataPoints", points},
+			{2, "aggregationTemporality", temporalityDelta},
+			{3, "isMonotonic", true},
+		}},
+	}
+}
+
+func deltaHistogram(name, description, unit string, points []message) message {
+	return message{
+		{1, "name", name},
+		{2, "description", description},
+		{3, "unit", unit},
+		{9, "histogram", message{
+			{1, "dataPoints", points},
+			{2, "aggregationTemporality", temporalityDelta},
+		}},
+	}
+}
+
+func intPoint(attrs []message, start, now time.Time, v int64) message {
+	return message{
+		{2, "startTimeUnixNano", nanos(start)},
+		{3, "timeUnixNano", nanos(now)},
+		{6, "asInt", set{sfixed64(v)}},
+		{7, "attributes", attrs},
+	}
+}
+
+func histogramPoint(attrs []message, start, now time.Time, buckets []float64, h *histogram) message {
+	return message{
+		{2, "startTimeUnixNano", nanos(start)},
+		{3, "timeUnixNano", nanos(now)},
+		{4, "count", set{fixed64(h.count)}},
+		{5, "sum", set{h.sum}},
+		{6, "bucketCounts", counts(h.counts)},
+		{7, "explicitBounds", bounds(buckets)},
+		{9, "attributes", attrs},
+	}
+}
+
+// phase is a part of a sample, between two of its timestamps.
+type phase struct {
+	name     string
+	from, to time.Time
+}
+
+// phases returns the parts of the sample that were completed, in order.
+// The TLS handshake is only a phase of its own for https targets.
+func phases(t sampler.Target, s sampler.Sample) []phase {
+	connected := s.TimeToConnect
+	if t.URL.URL != nil && t.URL.Scheme == "https" && !s.TimeToTCPConnect.IsZero() {
+		connected = s.TimeToTCPConnect
+	}
+
+	var all []phase
+	add := func(name string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			all = append(all, phase{name, from, to})
+		}
+	}
+	add("dns", s.TimeStart, s.TimeToResolveIP)
+	add("connect", s.TimeToResolveIP, connected)
+	if connected != s.TimeToConnect {
+		add("tls", connected, s.TimeToConnect)
+	}
+	add("ttfb", s.TimeToConnect, s.TimeToFirstByte)
+	add("download", s.TimeToFirstByte, s.TimeEnd)
+	return all
+}
+
+// spans returns a canary.probe span covering the sample, and a child span
+// for each of its phases.  If a traceparent header was sent, the probe span
+// has its trace and span ID, so that spans of the target join the trace.
+func spans(m sensor.Measurement) []message {
+	s := m.Sample
+	if s.TimeStart.IsZero() {
+		return nil
+	}
+
+	traceID, rootID := decodeID(s.TraceID, 16), decodeID(s.SpanID, 8)
+	if traceID == nil || rootID == nil {
+		traceID, rootID = randomID(16), randomID(8)
+	}
+
+	all := phases(m.Target, s)
+	end := s.TimeEnd
+	if end.IsZero() {
+		// the sample failed part way, so it ended with its last phase
+		end = s.TimeStart
+		if len(all) > 0 {
+			end = all[len(all)-1].to
+		}
+	}
+
+	attrs := targetAttributes(m.Target)
+	if s.StatusCode != 0 {
+		attrs = append(attrs, keyValue("http.response.status_code", int64(s.StatusCode)))
+	}
+	if s.RemoteAddr != nil {
+		attrs = append(attrs, keyValue("network.peer.address", s.RemoteAddr.String()))
+	}
+	root := message{
+		{1, "traceId", id(traceID)},
+		{2, "spanId", id(rootID)},
+		{5, "name", "canary.probe"},
+		{6, "kind", spanKindClient},
+		{7, "startTimeUnixNano", nanos(s.TimeStart)},
+		{8, "endTimeUnixNano", nanos(end)},
+		{9, "attributes", attrs},
+	}
+	if m.Error != nil {
+		root = append(root, field{15, "status", message{
+			{2, "message", m.Error.Error()},
+			{3, "code", statusError},
+		}})
+	}
+
+	out := []message{root}
+	for _, p := range all {
+		out = append(out, message{
+			{1, "traceId", id(traceID)},
+			{2, "spanId", id(randomID(8))},
+			{4, "parentSpanId", id(rootID)},
+			{5, "name", p.name},
+			{6, "kind", spanKindInternal},
+			{7, "startTimeUnixNano", nanos(p.from)},
+			{8, "endTimeUnixNano", nanos(p.to)},
+		})
+	}
+	return out
+}
+
+func decodeID(s string, n int) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != n {
+		return nil
+	}
+	return b
+}
+
+func randomID(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
@@ -0,0 +1,434 @@
+package otlppublisher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+// Protocol is an OTLP transport.
+type Protocol string
+
+const (
+	HTTPJSON     Protocol = "http/json"
+	HTTPProtobuf Protocol = "http/protobuf"
+	GRPC         Protocol = "grpc"
+)
+
+const (
+	// DefaultServiceName is the service.name of the exported resource if
+	// OTLP_SERVICE_NAME is unset.
+	DefaultServiceName = "canaryd"
+
+	// DefaultInterval is how often metrics and spans are exported if
+	// OTLP_INTERVAL is unset.
+	DefaultInterval = 10 * time.Second
+
+	// maxSpans bounds the spans queued between exports.
+	maxSpans = 10000
+
+	exportTimeout = 10 * time.Second
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// gRPC methods of the OTLP collector services, by signal.
+var grpcPaths = map[string]string{
+	"metrics": "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export",
+	"traces":  "/opentelemetry.proto.collector.trace.v1.TraceService/Export",
+}
+
+// Config configures a Publisher.
+type Config struct {
+	Endpoint    string // base URL of the collector, e.g. http://localhost:4318
+	Protocol    Protocol
+	Headers     map[string]string // sent with every export, e.g. for authentication
+	ServiceName string
+	Interval    time.Duration
+	Traces      bool // export a span for each sample
+}
+
+// Publisher implements the canary.Publisher interface, and exports metrics
+// aggregated per target, and optionally a trace of each sample, to an
+// OpenTelemetry collector every Interval.  Metrics use delta temporality,
+// so whatever was recorded since the previous export is exported.
+type Publisher struct {
+	config  Config
+	buckets []float64
+	client  *http.Client
+
+	mu     sync.Mutex
+	series map[string]*series // by target hash
+	spans  []message
+	start  time.Time // start of the current export interval
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type series struct {
+	attrs     []message
+	up        bool
+	samples   map[string]int64      // by result
+	latencies map[string]*histogram // by phase
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, the last being for values above every bound
+	count  uint64
+	sum    float64
+}
+
+// New returns a pointer to a new Publisher, exporting every
+// config.Interval until it is closed.
+func New(config Config) (*Publisher, error) {
+	if _, err := url.Parse(config.Endpoint); err != nil || !strings.Contains(config.Endpoint, "://") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, must be a URL such as http://localhost:4318", config.Endpoint)
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	switch config.Protocol {
+	case HTTPJSON, HTTPProtobuf:
+	case GRPC:
+		// gRPC requires HTTP/2, without TLS for http:// endpoints
+		transport.Protocols = new(http.Protocols)
+		if strings.HasPrefix(config.Endpoint, "http://") {
+			transport.Protocols.SetUnencryptedHTTP2(true)
+		} else {
+			transport.Protocols.SetHTTP2(true)
+		}
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q, must be http/json, http/protobuf or grpc", config.Protocol)
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = DefaultServiceName
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+
+	p := &Publisher{
+		config:  config,
+		buckets: DefaultBuckets,
+		client:  &http.Client{Transport: transport, Timeout: exportTimeout},
+		series:  make(map[string]*series),
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// NewFromEnv is a convenience func that wraps New, and populates its
+// arguments via environment variables.
+func NewFromEnv() (*Publisher, error) {
+	config := Config{
+		Endpoint:    os.Getenv("OTLP_ENDPOINT"),
+		Protocol:    Protocol(os.Getenv("OTLP_PROTOCOL")),
+		Headers:     make(map[string]string),
+		ServiceName: os.Getenv("OTLP_SERVICE_NAME"),
+		Traces:      os.Getenv("OTLP_TRACES") == "yes",
+	}
+	if config.Protocol == "" {
+		config.Protocol = HTTPJSON
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "http://localhost:4318"
+		if config.Protocol == GRPC {
+			config.Endpoint = "http://localhost:4317"
+		}
+	}
+
+	// headers are comma separated key=value pairs, with percent-encoded values
+	if s := os.Getenv("OTLP_HEADERS"); s != "" {
+		for _, pair := range strings.Split(s, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("OTLP_HEADERS is not a list of key=value pairs")
+			}
+			value, err := url.QueryUnescape(strings.TrimSpace(kv[1]))
+			if err != nil {
+				return nil, fmt.Errorf("OTLP_HEADERS: %s", err)
+			}
+			config.Headers[strings.TrimSpace(kv[0])] = value
+		}
+	}
+
+	if s := os.Getenv("OTLP_INTERVAL"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("OTLP_INTERVAL is not a valid positive number of seconds")
+		}
+		config.Interval = time.Duration(seconds) * time.Second
+	}
+
+	return New(config)
+}
+
+// Publish takes a canary.Measurement and records it in the metrics of its
+// target, and queues its spans if traces are exported.
+func (p *Publisher) Publish(m sensor.Measurement) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.series[m.Target.Hash]
+	if !ok {
+		s = &series{
+			attrs:     targetAttributes(m.Target),
+			samples:   make(map[string]int64),
+			latencies: make(map[string]*histogram),
+		}
+		p.series[m.Target.Hash] = s
+	}
+
+	// samples in maintenance leave the target's state as it was
+	if !m.InMaintenance {
+		s.up = m.IsOK
+	}
+	s.samples[result(m)]++
+	for phase, d := range latencies(m.Target, m.Sample) {
+		h, ok := s.latencies[phase]
+		if !ok {
+			h = &histogram{counts: make([]uint64, len(p.buckets)+1)}
+			s.latencies[phase] = h
+		}
+		h.observe(p.buckets, d.Seconds())
+	}
+
+	if p.config.Traces {
+		p.spans = append(p.spans, spans(m)...)
+		if over := len(p.spans) - maxSpans; over > 0 {
+			p.spans = p.spans[over:]
+		}
+	}
+	return
+}
+
+// RemoveTarget drops the metrics of a target that is no longer monitored.
+func (p *Publisher) RemoveTarget(t sampler.Target) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.series, t.Hash)
+}
+
+// Flush exports the metrics recorded, and the spans queued, since the
+// previous export.  They are dropped if the export fails.
+func (p *Publisher) Flush() error {
+	metrics, spans := p.collect(time.Now())
+
+	var errs []string
+	if len(metrics) > 0 {
+		if err := p.export("metrics", metricsRequest(p.config.ServiceName, metrics)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(spans) > 0 {
+		if err := p.export("traces", tracesRequest(p.config.ServiceName, spans)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("otlp: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close exports whatever is left and stops the publisher.
+func (p *Publisher) Close() error {
+	close(p.stop)
+	<-p.done
+	return p.Flush()
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	t := time.NewTicker(p.config.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-p.stop:
+			return
+		}
+		if err := p.Flush(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// collect builds the metrics of every target for the interval ending now,
+// and takes the queued spans, starting a new interval.
+func (p *Publisher) collect(now time.Time) (metrics, spans []message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start := p.start
+	p.start = now
+	spans, p.spans = p.spans, nil
+
+	hashes := make([]string, 0, len(p.series))
+	for hash := range p.series {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	var up, samples, durations []message
+	for _, hash := range hashes {
+		s := p.series[hash]
+
+		v := int64(0)
+		if s.up {
+			v = 1
+		}
+		up = append(up, intPoint(s.attrs, start, now, v))
+
+		for _, result := range sortedKeys(s.samples) {
+			attrs := append(s.attrs[:len(s.attrs):len(s.attrs)], keyValue("canary.result", result))
+			samples = append(samples, intPoint(attrs, start, now, s.samples[result]))
+		}
+		for _, phase := range sortedKeys(s.latencies) {
+			attrs := append(s.attrs[:len(s.attrs):len(s.attrs)], keyValue("canary.phase", phase))
+			durations = append(durations, histogramPoint(attrs, start, now, p.buckets, s.latencies[phase]))
+		}
+
+		// the deltas were exported, whether or not the export succeeds
+		s.samples = make(map[string]int64)
+		s.latencies = make(map[string]*histogram)
+	}
+
+	if len(up) > 0 {
+		metrics = append(metrics, gauge("canary.up", "Whether the target is up, once a change of state is confirmed.", "1", up))
+	}
+	if len(samples) > 0 {
+		metrics = append(metrics, deltaSum("canary.samples", "Samples taken, by result.", "{sample}", samples))
+	}
+	if len(durations) > 0 {
+		metrics = append(metrics, deltaHistogram("canary.sample.duration", "Duration of each phase of a sample.", "s", durations))
+	}
+	return metrics, spans
+}
+
+// export sends an export request for a signal, "metrics" or "traces".
+func (p *Publisher) export(signal string, req message) error {
+	var body []byte
+	var endpoint, contentType string
+	switch p.config.Protocol {
+	case HTTPJSON:
+		body, contentType = req.marshalJSON(), "application/json"
+		endpoint = p.config.Endpoint + "/v1/" + signal
+	case HTTPProtobuf:
+		body, contentType = req.marshalProto(), "application/x-protobuf"
+		endpoint = p.config.Endpoint + "/v1/" + signal
+	case GRPC:
+		// an uncompressed, length-prefixed message
+		proto := req.marshalProto()
+		body = make([]byte, 5, 5+len(proto))
+		binary.BigEndian.PutUint32(body[1:], uint32(len(proto)))
+		body = append(body, proto...)
+		contentType = "application/grpc"
+		endpoint = p.config.Endpoint + grpcPaths[signal]
+	}
+
+	r, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", contentType)
+	if p.config.Protocol == GRPC {
+		r.Header.Set("TE", "trailers")
+	}
+	for k, v := range p.config.Headers {
+		r.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(r)
+	if err != nil {
+		return fmt.Errorf("exporting %s: %s", signal, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("exporting %s: received HTTP status %d: %s", signal, resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	if p.config.Protocol == GRPC {
+		// the status is a trailer, or a header if the response has no body
+		status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+		if status == "" {
+			status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+		}
+		if status != "0" {
+			return fmt.Errorf("exporting %s: received gRPC status %q: %s", signal, status, message)
+		}
+	}
+	return nil
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	i := sort.SearchFloat64s(buckets, v)
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+// result classifies the outcome of a sample.
+func result(m sensor.Measurement) string {
+	if m.InMaintenance {
+		return "maintenance"
+	}
+	switch m.Error.(type) {
+	case nil:
+		return "ok"
+	case sampler.StatusCodeError, *sampler.StatusCodeError:
+		return "status_code"
+	default:
+		return "sampler_error"
+	}
+}
+
+// latencies returns the duration of each phase the sample completed, and of
+// the whole sample.
+func latencies(t sampler.Target, s sampler.Sample) map[string]time.Duration {
+	d := make(map[string]time.Duration)
+	for _, p := range phases(t, s) {
+		d[p.name] = p.to.Sub(p.from)
+	}
+	if !s.TimeStart.IsZero() && !s.TimeEnd.IsZero() {
+		d["total"] = s.TimeEnd.Sub(s.TimeStart)
+	}
+	return d
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]int64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*histogram:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
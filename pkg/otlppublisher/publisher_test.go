@@ -0,0 +1,273 @@
+package otlppublisher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+func measurement(name string, err error) sensor.Measurement {
+	u, _ := sampler.NewJsonURL("https://www.canary.io/status")
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	return sensor.Measurement{
+		Target: sampler.Target{
+			URL:        *u,
+			Name:       name,
+			Hash:       name,
+			Tags:       []string{"web"},
+			Attributes: map[string]string{"team": "ops"},
+		},
+		Sample: sampler.Sample{
+			StatusCode:       200,
+			TimeStart:        t1,
+			TimeToResolveIP:  t1.Add(10 * time.Millisecond),
+			TimeToTCPConnect: t1.Add(20 * time.Millisecond),
+			TimeToConnect:    t1.Add(50 * time.Millisecond),
+			TimeToFirstByte:  t1.Add(150 * time.Millisecond),
+			TimeEnd:          t1.Add(200 * time.Millisecond),
+			RemoteAddr:       net.ParseIP("192.0.2.1"),
+			TraceID:          "0af7651916cd43dd8448eb211c80319c",
+			SpanID:           "b7ad6b7169203331",
+		},
+		IsOK:  err == nil,
+		Error: err,
+	}
+}
+
+func TestMarshalProto(t *testing.T) {
+	m := message{
+		{1, "name", "up"},
+		{2, "unused", ""},
+		{3, "time", fixed64(1)},
+		{4, "value", set{sfixed64(0)}},
+		{5, "kind", enum(150)},
+		{6, "counts", counts{1, 2}},
+		{7, "nested", message{{1, "on", true}}},
+	}
+
+	expected := []byte{
+		0x0a, 2, 'u', 'p',
+		0x19, 1, 0, 0, 0, 0, 0, 0, 0,
+		0x21, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x28, 0x96, 0x01,
+		0x32, 16, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0,
+		0x3a, 2, 0x08, 1,
+	}
+	if b := m.marshalProto(); !bytes.Equal(b, expected) {
+		t.Fatalf("expected protobuf:\n% x\nbut got:\n% x", expected, b)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	m := message{
+		{1, "traceId", id{0xab, 0x01}},
+		{2, "empty", []message{}},
+		{3, "time", fixed64(1419724800000000000)},
+		{4, "asInt", set{sfixed64(0)}},
+		{5, "kind", enum(3)},
+		{6, "bucketCounts", counts{1, 2}},
+		{7, "explicitBounds", bounds{0.5, 1}},
+		{8, "attributes", []message{keyValue("name", "www")}},
+	}
+
+	expected := `{"traceId":"ab01","time":"1419724800000000000","asInt":"0","kind":3,` +
+		`"bucketCounts":["1","2"],"explicitBounds":[0.5,1],` +
+		`"attributes":[{"key":"name","value":{"stringValue":"www"}}]}`
+	if b := m.marshalJSON(); string(b) != expected {
+		t.Fatalf("expected JSON:\n%s\nbut got:\n%s", expected, b)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	all := spans(measurement("www", &sampler.StatusCodeError{StatusCode: 503}))
+
+	names := []string{"canary.probe", "dns", "connect", "tls", "ttfb", "download"}
+	if len(all) != len(names) {
+		t.Fatalf("expected %d spans, got %d", len(names), len(all))
+	}
+	for i, span := range all {
+		var s struct {
+			TraceID      string `json:"traceId"`
+			SpanID       string `json:"spanId"`
+			ParentSpanID string `json:"parentSpanId"`
+			Name         string `json:"name"`
+			Start        string `json:"startTimeUnixNano"`
+			End          string `json:"endTimeUnixNano"`
+			Status       struct {
+				Code int `json:"code"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal(span.marshalJSON(), &s); err != nil {
+			t.Fatal(err)
+		}
+
+		if s.Name != names[i] || s.TraceID != "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("expected span %d to be %s in the sampled trace, got %s in %s", i, names[i], s.Name, s.TraceID)
+		}
+		if i == 0 {
+			if s.SpanID != "b7ad6b7169203331" || s.ParentSpanID != "" || s.Status.Code != 2 {
+				t.Errorf("expected the probe span to be the root of the trace, with an error, got %+v", s)
+			}
+			if s.Start != "1419724800000000000" || s.End != "1419724800200000000" {
+				t.Errorf("expected the probe span to cover the sample, got %s to %s", s.Start, s.End)
+			}
+		} else if s.ParentSpanID != "b7ad6b7169203331" {
+			t.Errorf("expected %s to be a child of the probe span, got parent %q", s.Name, s.ParentSpanID)
+		}
+	}
+}
+
+func TestExportHTTPJSON(t *testing.T) {
+	requests := make(map[string]string)
+	var contentType, auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests[r.URL.Path] = string(body)
+		contentType, auth = r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	p, err := New(Config{
+		Endpoint: ts.URL,
+		Protocol: HTTPJSON,
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+		Interval: time.Hour,
+		Traces:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Publish(measurement("www", nil))
+	p.Publish(measurement("www", &sampler.StatusCodeError{StatusCode: 503}))
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/json" || auth != "Bearer secret" {
+		t.Fatalf("expected JSON with the configured headers, got %s and %q", contentType, auth)
+	}
+
+	var metrics struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Name  string
+					Gauge struct {
+						DataPoints []struct{ AsInt string }
+					}
+					Sum struct {
+						AggregationTemporality int
+						DataPoints             []struct{ AsInt string }
+					}
+					Histogram struct {
+						DataPoints []struct{ Count string }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(requests["/v1/metrics"]), &metrics); err != nil {
+		t.Fatalf("expected metrics to be exported, got %q: %s", requests["/v1/metrics"], err)
+	}
+	all := metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(all) != 3 || all[0].Name != "canary.up" || all[1].Name != "canary.samples" || all[2].Name != "canary.sample.duration" {
+		t.Fatalf("expected up, samples and duration metrics, got %+v", all)
+	}
+	if up := all[0].Gauge.DataPoints; len(up) != 1 || up[0].AsInt != "0" {
+		t.Errorf("expected the target to be down, got %+v", up)
+	}
+	if s := all[1].Sum; s.AggregationTemporality != 1 || len(s.DataPoints) != 2 {
+		t.Errorf("expected delta sums of each result, got %+v", s)
+	}
+	// dns, connect, tls, ttfb, download and total
+	if h := all[2].Histogram.DataPoints; len(h) != 6 || h[0].Count != "2" {
+		t.Errorf("expected a histogram of both samples for each phase, got %+v", h)
+	}
+
+	if n := strings.Count(requests["/v1/traces"], `"name":"canary.probe"`); n != 2 {
+		t.Errorf("expected a probe span for each sample, got %d in %s", n, requests["/v1/traces"])
+	}
+
+	// the deltas were exported, so only up is exported until the next sample
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(requests["/v1/metrics"], "canary.samples") {
+		t.Errorf("expected no samples since the previous export, got %s", requests["/v1/metrics"])
+	}
+}
+
+func TestExportGRPC(t *testing.T) {
+	var path, contentType string
+	var body []byte
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	p, err := New(Config{Endpoint: ts.URL, Protocol: GRPC, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Publish(measurement("www", nil))
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != grpcPaths["metrics"] || contentType != "application/grpc" {
+		t.Fatalf("expected a gRPC call to the metrics service, got %s with %s", path, contentType)
+	}
+	if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		t.Fatalf("expected a length-prefixed message, got % x", body)
+	}
+	// ExportMetricsServiceRequest.resource_metrics
+	if body[5] != 0x0a {
+		t.Fatalf("expected resource metrics, got % x", body[5:])
+	}
+}
+
+func TestExportGRPCError(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "16")
+		w.Header().Set("Grpc-Message", "missing credentials")
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	p, err := New(Config{Endpoint: ts.URL, Protocol: GRPC, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Publish(measurement("www", nil))
+	if err := p.Flush(); err == nil || !strings.Contains(err.Error(), "missing credentials") {
+		t.Fatalf("expected the gRPC status to be reported, got %v", err)
+	}
+}
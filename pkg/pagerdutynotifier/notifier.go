@@ -0,0 +1,135 @@
+package pagerdutynotifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/canaryio/canary/pkg/alert"
+)
+
+const (
+	// DefaultURL is the Events API v2 endpoint.
+	DefaultURL = "https://events.pagerduty.com/v2/enqueue"
+
+	// DefaultSeverity is the severity of incidents if none is configured.
+	DefaultSeverity = "critical"
+
+	requestTimeout = 10 * time.Second
+)
+
+// Settings configure a Notifier, as the settings of a pagerduty receiver.
+type Settings struct {
+	RoutingKey string `json:"routing_key"` // integration key of the service to page
+	Severity   string `json:"severity"`    // critical, error, warning or info
+	URL        string `json:"url"`         // defaults to DefaultURL
+}
+
+// Notifier implements the alert.Notifier interface, and triggers a
+// PagerDuty incident when an alert fires, resolving it when the alert is
+// resolved.  The alert's fingerprint is the dedup key, so that repeated
+// notifications update the same incident.
+type Notifier struct {
+	Settings
+	client *http.Client
+}
+
+// New returns a pointer to a new Notifier.
+func New(settings Settings) (*Notifier, error) {
+	if settings.RoutingKey == "" {
+		return nil, fmt.Errorf("pagerduty: routing_key must be set")
+	}
+	if settings.Severity == "" {
+		settings.Severity = DefaultSeverity
+	}
+	switch settings.Severity {
+	case "critical", "error", "warning", "info":
+	default:
+		return nil, fmt.Errorf("pagerduty: unknown severity %q, must be critical, error, warning or info", settings.Severity)
+	}
+	if settings.URL == "" {
+		settings.URL = DefaultURL
+	}
+	return &Notifier{settings, &http.Client{Timeout: requestTimeout}}, nil
+}
+
+// NewFromSettings is a convenience func that wraps New, and parses its
+// settings from the JSON object of a receiver.
+func NewFromSettings(raw json.RawMessage) (*Notifier, error) {
+	var s Settings
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("pagerduty: %s", err)
+	}
+	return New(s)
+}
+
+type event struct {
+	RoutingKey  string   `json:"routing_key"`
+	EventAction string   `json:"event_action"`
+	DedupKey    string   `json:"dedup_key"`
+	Payload     *payload `json:"payload,omitempty"`
+	Links       []link   `json:"links,omitempty"`
+}
+
+type payload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp"`
+	Component     string            `json:"component,omitempty"`
+	Class         string            `json:"class"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type link struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// Notify triggers or resolves the incident of the alert.
+func (n *Notifier) Notify(a alert.Alert) error {
+	e := event{
+		RoutingKey:  n.RoutingKey,
+		EventAction: "resolve",
+		DedupKey:    a.Fingerprint,
+	}
+
+	if a.Status == alert.Firing {
+		e.EventAction = "trigger"
+		url := ""
+		if a.Target.URL.URL != nil {
+			url = a.Target.URL.String()
+		}
+		e.Payload = &payload{
+			// the API limits summaries to 1024 characters
+			Summary:   alert.Truncate(a.Summary, 1024),
+			Source:    url,
+			Severity:  n.Severity,
+			Timestamp: a.StartsAt.UTC().Format(time.RFC3339),
+			Component: a.Target.Name,
+			Class:     a.Rule,
+			CustomDetails: map[string]string{
+				"rule":   a.Rule,
+				"target": a.Target.Name,
+				"url":    url,
+			},
+		}
+		if a.Measurement.Error != nil {
+			e.Payload.CustomDetails["error"] = a.Measurement.Error.Error()
+		}
+		for k, v := range a.Target.Attributes {
+			e.Payload.CustomDetails["attr."+k] = v
+		}
+		if url != "" {
+			e.Links = []link{{Href: url, Text: a.Target.Name}}
+		} else {
+			e.Payload.Source = a.Target.Name
+		}
+	}
+
+	if err := alert.PostJSON(n.client, n.URL, nil, e); err != nil {
+		return fmt.Errorf("pagerduty: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package pagerdutynotifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/alert"
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+func init() {
+	alert.RetryDelay = time.Millisecond
+}
+
+func firing() alert.Alert {
+	u, _ := sampler.NewJsonURL("https://www.canary.io")
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	return alert.Alert{
+		Rule:        "down",
+		Status:      alert.Firing,
+		Target:      sampler.Target{URL: *u, Name: "www"},
+		Summary:     "www has been down for 3 consecutive samples",
+		StartsAt:    t1,
+		Measurement: sensor.Measurement{Error: &sampler.StatusCodeError{StatusCode: 503}},
+		Fingerprint: "0123abcd",
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var events []map[string]interface{}
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		var e map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&e)
+		events = append(events, e)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	n, err := NewFromSettings(json.RawMessage(`{"routing_key": "key", "url": "` + ts.URL + `"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := firing()
+	if err := n.Notify(a); err != nil {
+		t.Fatal(err)
+	}
+	a.Status = alert.Resolved
+	if err := n.Notify(a); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 || attempts != 3 {
+		t.Fatalf("expected 2 events after a retry, got %d in %d attempts", len(events), attempts)
+	}
+	trigger, resolve := events[0], events[1]
+	if trigger["event_action"] != "trigger" || trigger["routing_key"] != "key" || trigger["dedup_key"] != "0123abcd" {
+		t.Errorf("unexpected trigger: %v", trigger)
+	}
+	payload := trigger["payload"].(map[string]interface{})
+	if payload["summary"] != a.Summary || payload["severity"] != "critical" || payload["source"] != "https://www.canary.io" || payload["timestamp"] != "2014-12-28T00:00:00Z" {
+		t.Errorf("unexpected payload: %v", payload)
+	}
+	if details := payload["custom_details"].(map[string]interface{}); details["error"] != "recieved HTTP status 503" {
+		t.Errorf("expected the error in the details, got %v", details)
+	}
+	if resolve["event_action"] != "resolve" || resolve["dedup_key"] != "0123abcd" || resolve["payload"] != nil {
+		t.Errorf("unexpected resolve: %v", resolve)
+	}
+}
+
+func TestNotifyRejected(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, `{"status":"invalid event"}`, http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	n, err := New(Settings{RoutingKey: "key", URL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(firing()); err == nil || attempts != 1 {
+		t.Fatalf("expected the rejected event to fail without a retry, got %v after %d attempts", err, attempts)
+	}
+}
@@ -0,0 +1,296 @@
+package prometheuspublisher
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+// DefaultAddr is the address /metrics is served on if PROMETHEUS_ADDR is unset.
+const DefaultAddr = ":9110"
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// phases are the parts of a sample whose latency is recorded, in order.
+var phases = []string{"resolve", "connect", "first_byte", "transfer", "total"}
+
+// results are the outcomes samples are counted by.
+var results = []string{"ok", "status_code", "sampler_error", "maintenance"}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Publisher implements the canary.Publisher interface, and keeps the
+// latest state of every target to be scraped by Prometheus.
+type Publisher struct {
+	Buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series // by target hash
+}
+
+// series holds the metrics of a single target.
+type series struct {
+	labels     string // rendered name="value" pairs
+	up         bool
+	lastSample time.Time
+	tlsExpiry  time.Time
+	samples    map[string]uint64 // by result
+	latencies  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// New returns a pointer to a new Publisher.  It does not serve metrics by
+// itself; use it as the handler of /metrics.
+func New() *Publisher {
+	return &Publisher{
+		Buckets: DefaultBuckets,
+		series:  make(map[string]*series),
+	}
+}
+
+// NewFromEnv is a convenience func that wraps New, and serves /metrics on
+// PROMETHEUS_ADDR, or DefaultAddr if it is unset.
+func NewFromEnv() (*Publisher, error) {
+	addr := os.Getenv("PROMETHEUS_ADDR")
+	if addr == "" {
+		addr = DefaultAddr
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("PROMETHEUS_ADDR: %s", err)
+	}
+
+	p := New()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", p)
+	go http.Serve(l, mux)
+
+	return p, nil
+}
+
+// Publish takes a canary.Measurement and records it in the series of its
+// target.
+func (p *Publisher) Publish(m sensor.Measurement) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.series[m.Target.Hash]
+	if !ok {
+		s = &series{
+			labels:    labels(m.Target),
+			samples:   make(map[string]uint64),
+			latencies: make(map[string]*histogram),
+		}
+		p.series[m.Target.Hash] = s
+	}
+
+	// samples in maintenance leave the target's state as it was
+	if !m.InMaintenance {
+		s.up = m.IsOK
+	}
+	// samples that fail before they start have no times
+	s.lastSample = m.Sample.TimeEnd
+	if s.lastSample.IsZero() {
+		s.lastSample = time.Now()
+	}
+	// keep the last known expiry if the handshake was not reached
+	if !m.Sample.TLSNotAfter.IsZero() {
+		s.tlsExpiry = m.Sample.TLSNotAfter
+	}
+	s.samples[result(m)]++
+
+	for phase, d := range latencies(m.Sample) {
+		h, ok := s.latencies[phase]
+		if !ok {
+			h = &histogram{counts: make([]uint64, len(p.Buckets))}
+			s.latencies[phase] = h
+		}
+		h.observe(p.Buckets, d.Seconds())
+	}
+
+	return
+}
+
+// RemoveTarget drops the series of a target that is no longer monitored.
+func (p *Publisher) RemoveTarget(t sampler.Target) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.series, t.Hash)
+}
+
+// ServeHTTP writes every series in the Prometheus text exposition format.
+func (p *Publisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.write(w)
+}
+
+func (p *Publisher) write(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	all := make([]*series, 0, len(p.series))
+	for _, s := range p.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].labels < all[j].labels })
+
+	family := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	family("canary_up", "gauge", "Whether the target is up, once a change of state is confirmed.")
+	for _, s := range all {
+		up := 0
+		if s.up {
+			up = 1
+		}
+		fmt.Fprintf(w, "canary_up{%s} %d\n", s.labels, up)
+	}
+
+	family("canary_samples_total", "counter", "Samples taken, by result.")
+	for _, s := range all {
+		for _, res := range results {
+			fmt.Fprintf(w, "canary_samples_total{%s,result=%q} %d\n", s.labels, res, s.samples[res])
+		}
+	}
+
+	family("canary_sample_duration_seconds", "histogram", "Latency of samples, by phase.")
+	for _, s := range all {
+		for _, phase := range phases {
+			h, ok := s.latencies[phase]
+			if !ok {
+				continue
+			}
+			l := fmt.Sprintf("%s,phase=%q", s.labels, phase)
+			var cumulative uint64
+			for i, bound := range p.Buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(w, "canary_sample_duration_seconds_bucket{%s,le=\"%g\"} %d\n", l, bound, cumulative)
+			}
+			fmt.Fprintf(w, "canary_sample_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+			fmt.Fprintf(w, "canary_sample_duration_seconds_sum{%s} %s\n", l, value(h.sum))
+			fmt.Fprintf(w, "canary_sample_duration_seconds_count{%s} %d\n", l, h.count)
+		}
+	}
+
+	family("canary_last_sample_timestamp_seconds", "gauge", "Unix time of the last sample of the target.")
+	for _, s := range all {
+		fmt.Fprintf(w, "canary_last_sample_timestamp_seconds{%s} %s\n", s.labels, value(unix(s.lastSample)))
+	}
+
+	family("canary_tls_cert_expiry_timestamp_seconds", "gauge", "Unix time the certificate of an https target expires.")
+	for _, s := range all {
+		if !s.tlsExpiry.IsZero() {
+			fmt.Fprintf(w, "canary_tls_cert_expiry_timestamp_seconds{%s} %s\n", s.labels, value(unix(s.tlsExpiry)))
+		}
+	}
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	for i, bound := range buckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// labels renders the labels of a target: its name, URL and tags, and an
+// attr_<key> label for each attribute.  Keys that only differ by invalid
+// characters, such as a-b and a_b, would share a label, so the later ones
+// in sorted order are numbered instead, as attr_a_b_2.
+func labels(t sampler.Target) string {
+	tags := append([]string(nil), t.Tags...)
+	sort.Strings(tags)
+
+	pairs := []string{
+		"name=" + quote(t.Name),
+		"url=" + quote(t.URL.String()),
+		"tags=" + quote(strings.Join(tags, ",")),
+	}
+
+	keys := make([]string, 0, len(t.Attributes))
+	for k := range t.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	used := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		name := "attr_" + invalidLabelChars.ReplaceAllString(k, "_")
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("attr_%s_%d", invalidLabelChars.ReplaceAllString(k, "_"), i)
+		}
+		used[name] = true
+		pairs = append(pairs, name+"="+quote(t.Attributes[k]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// quote escapes a label value as the exposition format requires.
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+// result classifies the outcome of a sample.
+func result(m sensor.Measurement) string {
+	if m.InMaintenance {
+		return "maintenance"
+	}
+	switch m.Error.(type) {
+	case nil:
+		return "ok"
+	case sampler.StatusCodeError, *sampler.StatusCodeError:
+		return "status_code"
+	default:
+		return "sampler_error"
+	}
+}
+
+// latencies returns the duration of each phase the sample completed.
+func latencies(s sampler.Sample) map[string]time.Duration {
+	d := make(map[string]time.Duration)
+	between := func(phase string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			d[phase] = to.Sub(from)
+		}
+	}
+	between("resolve", s.TimeStart, s.TimeToResolveIP)
+	between("connect", s.TimeToResolveIP, s.TimeToConnect)
+	between("first_byte", s.TimeToConnect, s.TimeToFirstByte)
+	between("transfer", s.TimeToFirstByte, s.TimeEnd)
+	between("total", s.TimeStart, s.TimeEnd)
+	return d
+}
+
+// value formats a sample value without losing precision.
+func value(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func unix(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
@@ -0,0 +1,99 @@
+package prometheuspublisher
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+func target(name string) sampler.Target {
+	u, _ := sampler.NewJsonURL("https://www.canary.io/")
+	t := sampler.Target{
+		URL:        *u,
+		Name:       name,
+		Tags:       []string{"web", "prod"},
+		Attributes: map[string]string{"team": "ops", "source-file": `a"b`},
+	}
+	t.SetHash()
+	return t
+}
+
+func scrape(p *Publisher) string {
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestPublish(t *testing.T) {
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	expiry, _ := time.Parse(time.RFC3339, "2015-12-28T00:00:00Z")
+	sample := sampler.Sample{
+		TimeStart:       t1,
+		TimeToResolveIP: t1.Add(10 * time.Millisecond),
+		TimeToConnect:   t1.Add(30 * time.Millisecond),
+		TimeToFirstByte: t1.Add(230 * time.Millisecond),
+		TimeEnd:         t1.Add(2 * time.Second),
+		StatusCode:      200,
+		TLSNotAfter:     expiry,
+	}
+
+	p := New()
+	p.Publish(sensor.Measurement{Target: target("test"), Sample: sample, IsOK: true})
+	p.Publish(sensor.Measurement{Target: target("test"), Sample: sample, Error: &sampler.StatusCodeError{StatusCode: 500}})
+	p.Publish(sensor.Measurement{Target: target("test"), Sample: sample, Error: fmt.Errorf("connecting: refused")})
+
+	labels := `name="test",url="https://www.canary.io/",tags="prod,web",attr_source_file="a\"b",attr_team="ops"`
+	expected := []string{
+		`canary_up{` + labels + `} 0`,
+		`canary_samples_total{` + labels + `,result="ok"} 1`,
+		`canary_samples_total{` + labels + `,result="status_code"} 1`,
+		`canary_samples_total{` + labels + `,result="sampler_error"} 1`,
+		`canary_sample_duration_seconds_bucket{` + labels + `,phase="resolve",le="0.01"} 3`,
+		`canary_sample_duration_seconds_bucket{` + labels + `,phase="first_byte",le="0.1"} 0`,
+		`canary_sample_duration_seconds_bucket{` + labels + `,phase="first_byte",le="0.25"} 3`,
+		`canary_sample_duration_seconds_bucket{` + labels + `,phase="total",le="+Inf"} 3`,
+		`canary_sample_duration_seconds_sum{` + labels + `,phase="total"} 6`,
+		`canary_last_sample_timestamp_seconds{` + labels + `} 1419724802`,
+		`canary_tls_cert_expiry_timestamp_seconds{` + labels + `} 1451260800`,
+		`# TYPE canary_sample_duration_seconds histogram`,
+	}
+
+	metrics := scrape(p)
+	for _, line := range expected {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("expected metrics to contain %s, got:\n%s", line, metrics)
+		}
+	}
+}
+
+func TestLabelsOfCollidingAttributes(t *testing.T) {
+	target := target("test")
+	target.Attributes = map[string]string{"a-b": "1", "a_b": "2", "a.b": "3", "a_b_2": "4"}
+
+	got := labels(target)
+	expected := `name="test",url="https://www.canary.io/",tags="prod,web",attr_a_b="1",attr_a_b_2="3",attr_a_b_3="2",attr_a_b_2_2="4"`
+	if got != expected {
+		t.Fatalf("expected labels %s, got %s", expected, got)
+	}
+}
+
+func TestRemoveTarget(t *testing.T) {
+	p := New()
+	p.Publish(sensor.Measurement{Target: target("kept"), IsOK: true})
+	p.Publish(sensor.Measurement{Target: target("removed"), IsOK: true})
+
+	p.RemoveTarget(target("removed"))
+
+	metrics := scrape(p)
+	if !strings.Contains(metrics, `name="kept"`) {
+		t.Errorf("expected the kept target to be exported, got:\n%s", metrics)
+	}
+	if strings.Contains(metrics, `name="removed"`) {
+		t.Errorf("expected the removed target not to be exported, got:\n%s", metrics)
+	}
+}
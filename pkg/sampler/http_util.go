@@ -50,7 +50,9 @@ func parseHeaders(r *bufio.Reader) (http.Header, error) {
 	return headers, nil
 }
 
-func genRequest(t Target) (string, error) {
+// genRequest renders the request for a target.  traceparent, if set, is
+// sent as the W3C traceparent header.
+func genRequest(t Target, traceparent string) (string, error) {
 	// allow Host header to be set via t.RequestHeaders
 	// otherwise, use the host of the URL
 	hostHeader := t.RequestHeaders["Host"]
@@ -68,6 +70,10 @@ func genRequest(t Target) (string, error) {
 		}
 	}
 
+	if traceparent != "" {
+		req += fmt.Sprintf("traceparent: %s\r\n", traceparent)
+	}
+
 	// trailing newline
 	req += "\r\n"
 
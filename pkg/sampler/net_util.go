@@ -35,20 +35,37 @@ func resolveIPAddr(host string, deadline time.Time) (net.IP, error) {
 	}
 }
 
-func dial(scheme string, addr string, serverName string, deadline time.Time, insecure bool) (net.Conn, error) {
+// dial connects to addr, with TLS for https.  The time the TCP connection
+// was established, before any TLS handshake, is stored in tcpConnected.
+func dial(scheme string, addr string, serverName string, deadline time.Time, insecure bool, tcpConnected *time.Time) (net.Conn, error) {
 	dialer := &net.Dialer{
 		Deadline: deadline,
 	}
 
 	switch scheme {
-	case "http":
-		return dialer.Dial("tcp", addr)
-	case "https":
-		return tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
-			ServerName:         serverName,
-			InsecureSkipVerify: insecure,
-		})
+	case "http", "https":
 	default:
 		return nil, fmt.Errorf("unknown scheme '%s'", scheme)
 	}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	*tcpConnected = time.Now()
+
+	if scheme == "http" {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+	})
+	conn.SetDeadline(deadline)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
 }
@@ -2,6 +2,7 @@ package sampler
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -11,15 +12,21 @@ import (
 )
 
 type Sample struct {
-	StatusCode      int
-	TimeStart       time.Time
-	TimeToResolveIP time.Time
-	TimeToConnect   time.Time
-	TimeToFirstByte time.Time
-	TimeEnd         time.Time
-	ResponseHeaders http.Header
-	LocalAddr       net.IP
-	RemoteAddr      net.IP
+	StatusCode       int
+	TimeStart        time.Time
+	TimeToResolveIP  time.Time
+	TimeToTCPConnect time.Time // before the TLS handshake, for https targets
+	TimeToConnect    time.Time
+	TimeToFirstByte  time.Time
+	TimeEnd          time.Time
+	ResponseHeaders  http.Header
+	LocalAddr        net.IP
+	RemoteAddr       net.IP
+	TLSNotAfter      time.Time // expiry of the server certificate, for https targets
+
+	// the trace context sent in the traceparent header, if any
+	TraceID string
+	SpanID  string
 }
 
 // StatusCodeError is an error representing an HTTP Status code
@@ -68,7 +75,7 @@ func Ping(target Target, timeout int) (sample Sample, err error) {
 		ipStr = "[" + ipStr + "]"
 	}
 	
-	conn, err := dial(target.URL.Scheme, ipStr + ":" + port, hostname, deadline, target.InsecureSkipVerify)
+	conn, err := dial(target.URL.Scheme, ipStr + ":" + port, hostname, deadline, target.InsecureSkipVerify, &sample.TimeToTCPConnect)
 	if err != nil {
 		err = fmt.Errorf("connecting: %s", err)
 		return
@@ -78,8 +85,19 @@ func Ping(target Target, timeout int) (sample Sample, err error) {
 
 	sample.TimeToConnect = time.Now()
 	sample.LocalAddr = conn.LocalAddr().(*net.TCPAddr).IP
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			sample.TLSNotAfter = certs[0].NotAfter
+		}
+	}
+
+	traceparent := ""
+	if InjectTraceparent {
+		sample.TraceID, sample.SpanID = newTraceContext()
+		traceparent = sample.Traceparent()
+	}
 
-	req, err := genRequest(target)
+	req, err := genRequest(target, traceparent)
 	if err != nil {
 		return
 	}
@@ -120,7 +138,14 @@ func Ping(target Target, timeout int) (sample Sample, err error) {
 		}
 	}
 
-	if sample.StatusCode >= 400 {
+	// targets may expect a specific status, otherwise any status below 400 is healthy
+	if target.ExpectedStatus != 0 {
+		if sample.StatusCode != target.ExpectedStatus {
+			err = &StatusCodeError{
+				StatusCode: sample.StatusCode,
+			}
+		}
+	} else if sample.StatusCode >= 400 {
 		err = &StatusCodeError{
 			StatusCode: sample.StatusCode,
 		}
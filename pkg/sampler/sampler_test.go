@@ -246,7 +246,7 @@ func TestGenRequest(t *testing.T) {
 		URL: parseUrl("http://canary.io"),
 	}
 
-	req, err := genRequest(target)
+	req, err := genRequest(target, "")
 	if err != nil {
 		t.Fatalf("err while generating request: %v\n", err)
 	}
@@ -268,7 +268,7 @@ func TestGenRequestWithCustomHost(t *testing.T) {
 		RequestHeaders: headers,
 	}
 
-	req, err := genRequest(target)
+	req, err := genRequest(target, "")
 	if err != nil {
 		t.Fatalf("err while generating request: %v\n", err)
 	}
@@ -294,3 +294,51 @@ func TestConnectSecurelyWithVerify(t *testing.T) {
 	}
 }
 
+
+func TestSampleRecordsCertificateExpiry(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	target := Target{
+		URL:                parseUrl(ts.URL),
+		InsecureSkipVerify: true,
+	}
+
+	sample, err := Ping(target, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sample.TimeToTCPConnect.Before(sample.TimeToConnect) {
+		t.Fatalf("Expected the TCP connection before the TLS handshake, but got %s and %s\n", sample.TimeToTCPConnect, sample.TimeToConnect)
+	}
+
+	notAfter := ts.Certificate().NotAfter
+	if !sample.TLSNotAfter.Equal(notAfter) {
+		t.Fatalf("Expected TLSNotAfter == %s, but got %s\n", notAfter, sample.TLSNotAfter)
+	}
+}
+
+func TestSampleWithTraceparent(t *testing.T) {
+	var traceparent string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	InjectTraceparent = true
+	defer func() { InjectTraceparent = false }()
+
+	sample, err := Ping(Target{URL: parseUrl(ts.URL)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sample.TraceID) != 32 || len(sample.SpanID) != 16 {
+		t.Fatalf("Expected a trace context to be recorded, but got %q and %q\n", sample.TraceID, sample.SpanID)
+	}
+	if traceparent != sample.Traceparent() || !strings.HasPrefix(traceparent, "00-"+sample.TraceID) {
+		t.Fatalf("Expected traceparent header %q, but got %q\n", sample.Traceparent(), traceparent)
+	}
+}
@@ -16,6 +16,14 @@ type Target struct {
 	Hash               string
 	RequestHeaders     map[string]string
 	InsecureSkipVerify bool
+	ExpectedStatus     int // if set, any other status is an error
+
+	// stabilization of the target's state, see sensor.Sensor
+	ConfirmFailures   int  // failed samples before the target is down, defaults to 1
+	ConfirmRecoveries int  // successful samples before it is up again, defaults to 1
+	RetryFailures     bool // sample again at once before a failure counts
+	FlapWindow        int  // seconds over which state changes are counted
+	FlapThreshold     int  // state changes within FlapWindow that mark it flapping
 }
 
 func (t *Target) SetHash() {
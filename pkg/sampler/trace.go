@@ -0,0 +1,27 @@
+package sampler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// InjectTraceparent makes Ping send a W3C traceparent header with every
+// request, so that samples can be followed into the traces of the target.
+// The trace context sent is recorded in the Sample.
+var InjectTraceparent bool
+
+// newTraceContext returns a random trace ID and span ID, hex encoded.
+func newTraceContext() (traceID, spanID string) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b[:16]), hex.EncodeToString(b[16:])
+}
+
+// Traceparent returns the W3C traceparent header value of the sample's
+// trace context, or "" if it has none.
+func (s Sample) Traceparent() string {
+	if s.TraceID == "" {
+		return ""
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-01"
+}
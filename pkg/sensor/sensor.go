@@ -1,22 +1,42 @@
 package sensor
 
 import (
+	"sync"
 	"time"
 
 	"github.com/canaryio/canary/pkg/sampler"
 )
 
 // Measurement reprents an aggregate of Target, Sample and error.
+//
+// IsOK is the stabilized state of the target, which only changes once the
+// target's ConfirmFailures or ConfirmRecoveries samples in a row agree;
+// SampleOK is the result of this sample alone.
 type Measurement struct {
 	Target     sampler.Target
 	Sample     sampler.Sample
 	IsOK       bool
-	StateCount int
+	StateCount int // consecutive samples in the stabilized state
+	SampleOK   bool
+	Retried    bool // the sample failed, and was taken again at once
+	Flapping   bool // the target changes state too often, see Sensor
 	Error      error
+
+	// InMaintenance is set by canary for targets in a maintenance window or
+	// silence.  Such measurements are published, but errors during
+	// maintenance are expected, and say nothing of the target's
+	// availability: publishers do not count them as errors, and alerts
+	// ignore them.
+	InMaintenance bool
 }
 
 // Sensor is capable of repeatedly measuring a given Target
 // with a specific Sampler, and returns those results over channel C.
+//
+// A sensor considers its target up until it fails ConfirmFailures samples
+// in a row, and down until it succeeds ConfirmRecoveries samples in a row.
+// The target is flapping while its state changed FlapThreshold times or
+// more within the last FlapWindow seconds.
 type Sensor struct {
 	Target         sampler.Target
 	C              chan Measurement
@@ -26,31 +46,113 @@ type Sensor struct {
 	StopNotifyChan chan bool
 	IsOK           bool
 	Timeout        int // timeout in secs
+
+	mu          sync.Mutex // guards IsOK, StateCounter and the fields below
+	paused      bool
+	since       time.Time
+	lastSample  time.Time
+	lastLatency time.Duration
+	lastError   error
+
+	unconfirmed      int       // consecutive samples disagreeing with IsOK
+	unconfirmedSince time.Time // when the first of them was taken
+	changes          []time.Time
+	flapping         bool
+}
+
+// State is a snapshot of a Sensor's state.
+type State struct {
+	IsOK       bool
+	StateCount int
+	Paused     bool
+	Flapping   bool
+
+	Since       time.Time     // when the sensor entered its current state
+	LastSample  time.Time     // zero until the first sample is taken
+	LastLatency time.Duration // zero if the last sample did not complete
+	LastError   error
 }
 
 // take a sample against a target.
 func (s *Sensor) measure() Measurement {
 	sample, err := sampler.Ping(s.Target, s.Timeout)
+	retried := false
+	if err != nil && s.Target.RetryFailures {
+		sample, err = sampler.Ping(s.Target, s.Timeout)
+		retried = true
+	}
 	m := Measurement{
-		Target: s.Target,
-		Sample: sample,
-		Error:  err,
+		Target:  s.Target,
+		Sample:  sample,
+		Retried: retried,
+		Error:   err,
 	}
 
 	// Record the pass/fail for this measurement
-	m.IsOK = (m.Error == nil)
+	m.SampleOK = (m.Error == nil)
 
-	// Update the Sensors value for IsOK and counter for said state.
-	if s.IsOK != m.IsOK {
-		s.IsOK = m.IsOK
-		s.StateCounter = 0
-	}
-	s.StateCounter++
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.record(m.SampleOK, now)
+	m.IsOK = s.IsOK
 	m.StateCount = s.StateCounter
+	m.Flapping = s.flapping
+
+	s.lastSample = now
+	s.lastError = m.Error
+	s.lastLatency = 0
+	if !sample.TimeEnd.IsZero() {
+		s.lastLatency = sample.TimeEnd.Sub(sample.TimeStart)
+	}
 
 	return m
 }
 
+// record updates the stabilized state of the sensor with the result of a
+// sample taken at now.  s.mu must be held.
+func (s *Sensor) record(ok bool, now time.Time) {
+	// targets are up until proven otherwise
+	first := s.since.IsZero()
+	if first {
+		s.IsOK = true
+		s.since = now
+	}
+
+	if ok == s.IsOK {
+		s.unconfirmed = 0
+		s.StateCounter++
+	} else {
+		if s.unconfirmed == 0 {
+			s.unconfirmedSince = now
+		}
+		s.unconfirmed++
+
+		confirm := s.Target.ConfirmFailures
+		if ok {
+			confirm = s.Target.ConfirmRecoveries
+		}
+		if s.unconfirmed >= confirm {
+			s.IsOK = ok
+			s.StateCounter = s.unconfirmed
+			s.since = s.unconfirmedSince
+			s.unconfirmed = 0
+			if !first {
+				s.changes = append(s.changes, now)
+			}
+		}
+	}
+
+	// forget the state changes that are out of the flap window
+	window := time.Duration(s.Target.FlapWindow) * time.Second
+	i := 0
+	for i < len(s.changes) && now.Sub(s.changes[i]) > window {
+		i++
+	}
+	s.changes = s.changes[i:]
+	s.flapping = s.Target.FlapThreshold > 0 && len(s.changes) >= s.Target.FlapThreshold
+}
+
 // Start is meant to be called within a goroutine, and fires up the main event loop.
 // interval is number of seconds. delay is number of ms.
 func (s *Sensor) Start(delay float64) {
@@ -71,11 +173,70 @@ func (s *Sensor) Start(delay float64) {
 			s.StopNotifyChan <- true
 			return
 		default:
-			s.C <- s.measure()
+			if !s.IsPaused() {
+				s.C <- s.measure()
+			}
 		}
 	}
 }
 
+// Inherit carries the stabilized state of old, the stopped sensor of a
+// target whose settings changed, over to s before s is started, so that the
+// change neither resets nor confirms the state of the target.  Pausing is
+// not carried over.
+func (s *Sensor) Inherit(old *Sensor) {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.IsOK = old.IsOK
+	s.StateCounter = old.StateCounter
+	s.since = old.since
+	s.unconfirmed = old.unconfirmed
+	s.unconfirmedSince = old.unconfirmedSince
+	s.changes = append([]time.Time(nil), old.changes...)
+	s.flapping = old.flapping
+}
+
+// Pause stops the sensor from taking samples until it is resumed.
+func (s *Sensor) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+}
+
+// Resume undoes Pause.
+func (s *Sensor) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+}
+
+// IsPaused reports whether the sensor is paused.
+func (s *Sensor) IsPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// State returns a snapshot of the sensor's state.  It is safe to call
+// while the sensor is running.
+func (s *Sensor) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return State{
+		IsOK:        s.IsOK,
+		StateCount:  s.StateCounter,
+		Paused:      s.paused,
+		Flapping:    s.flapping,
+		Since:       s.since,
+		LastSample:  s.lastSample,
+		LastLatency: s.lastLatency,
+		LastError:   s.lastError,
+	}
+}
+
 // Stop halts the event loop.
 func (s *Sensor) Stop() {
 	s.StopChan <- 1
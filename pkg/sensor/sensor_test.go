@@ -0,0 +1,132 @@
+package sensor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+// replay records a sequence of sample results, one per second, and returns
+// the stabilized state after each.
+func replay(s *Sensor, results string) (states string, counts []int) {
+	t0 := time.Now()
+	for i, r := range results {
+		s.record(r == '+', t0.Add(time.Duration(i)*time.Second))
+		if s.IsOK {
+			states += "+"
+		} else {
+			states += "-"
+		}
+		counts = append(counts, s.StateCounter)
+	}
+	return
+}
+
+func TestRecord(t *testing.T) {
+	s := &Sensor{}
+	if states, _ := replay(s, "+-+--+"); states != "+-+--+" {
+		t.Errorf("expected every sample to change the state by default, got %s", states)
+	}
+
+	s = &Sensor{Target: sampler.Target{ConfirmFailures: 3, ConfirmRecoveries: 2}}
+	states, counts := replay(s, "+--+---+-++")
+	if want := "++++++----+"; states != want {
+		t.Errorf("expected states %s, got %s", want, states)
+	}
+	if want := []int{1, 1, 1, 2, 2, 2, 3, 3, 4, 4, 2}; !equal(counts, want) {
+		t.Errorf("expected state counts %v, got %v", want, counts)
+	}
+}
+
+func TestRecordFirstSampleFails(t *testing.T) {
+	s := &Sensor{Target: sampler.Target{ConfirmFailures: 2}}
+	if states, _ := replay(s, "--"); states != "+-" {
+		t.Errorf("expected targets to be up until a failure is confirmed, got %s", states)
+	}
+	if s.StateCounter != 2 {
+		t.Errorf("expected a state count of 2, got %d", s.StateCounter)
+	}
+}
+
+func TestRecordFlapping(t *testing.T) {
+	s := &Sensor{Target: sampler.Target{FlapWindow: 5, FlapThreshold: 3}}
+	t0 := time.Now()
+
+	var flapping []bool
+	for i, ok := range []bool{true, false, true, false, false, false, false, false, false} {
+		s.record(ok, t0.Add(time.Duration(i)*time.Second))
+		flapping = append(flapping, s.flapping)
+	}
+
+	// changes at 1s, 2s and 3s: flapping from 3s until the first of them
+	// falls out of the window, after 6s
+	want := []bool{false, false, false, true, true, true, true, false, false}
+	for i := range want {
+		if flapping[i] != want[i] {
+			t.Fatalf("expected flapping %v, got %v", want, flapping)
+		}
+	}
+}
+
+func TestMeasureRetryFailures(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := sampler.NewJsonURL(ts.URL)
+
+	s := &Sensor{Target: sampler.Target{URL: *u}, Timeout: 5}
+	m := s.measure()
+	if m.SampleOK || m.IsOK || m.Retried {
+		t.Errorf("expected a failed sample without retry, got SampleOK %t, IsOK %t, Retried %t", m.SampleOK, m.IsOK, m.Retried)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	s = &Sensor{Target: sampler.Target{URL: *u, RetryFailures: true}, Timeout: 5}
+	m = s.measure()
+	if !m.SampleOK || !m.IsOK || !m.Retried {
+		t.Errorf("expected a retried, successful sample, got SampleOK %t, IsOK %t, Retried %t", m.SampleOK, m.IsOK, m.Retried)
+	}
+	if m.Error != nil {
+		t.Errorf("expected no error, got %s", m.Error)
+	}
+}
+
+func TestMeasureConfirmFailures(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	u, _ := sampler.NewJsonURL(ts.URL)
+	s := &Sensor{Target: sampler.Target{URL: *u, ConfirmFailures: 2}, Timeout: 5}
+
+	m := s.measure()
+	if m.SampleOK || !m.IsOK || m.Error == nil {
+		t.Errorf("expected an unconfirmed failure, got SampleOK %t, IsOK %t, Error %v", m.SampleOK, m.IsOK, m.Error)
+	}
+	m = s.measure()
+	if m.SampleOK || m.IsOK || m.StateCount != 2 {
+		t.Errorf("expected a confirmed failure, got SampleOK %t, IsOK %t, StateCount %d", m.SampleOK, m.IsOK, m.StateCount)
+	}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
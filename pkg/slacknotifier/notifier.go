@@ -0,0 +1,285 @@
+package slacknotifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canaryio/canary/pkg/alert"
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+const (
+	// DefaultMaxMessages is how many messages are posted to a channel per
+	// Interval if max_messages is unset.
+	DefaultMaxMessages = 5
+
+	// DefaultInterval is the rate limiting interval if interval is unset.
+	DefaultInterval = time.Minute
+
+	// maxSummaryLines bounds the alerts listed in a summary.
+	maxSummaryLines = 20
+
+	requestTimeout = 10 * time.Second
+)
+
+// Settings configure a Notifier, as the settings of a slack receiver.
+type Settings struct {
+	WebhookURL string `json:"webhook_url"` // a Slack-compatible incoming webhook
+
+	// Channel overrides the webhook's channel.  Channels maps tags to
+	// channels: alerts of a target with one of the tags are posted to its
+	// channel, by the first of the target's tags that has one.
+	Channel  string            `json:"channel"`
+	Channels map[string]string `json:"channels"`
+
+	Username  string `json:"username"`
+	IconEmoji string `json:"icon_emoji"`
+
+	// At most MaxMessages are posted to a channel per Interval.  Alerts
+	// beyond that are posted together, as a summary, at the end of the
+	// interval.
+	MaxMessages int            `json:"max_messages"`
+	Interval    alert.Duration `json:"interval"`
+}
+
+// Notifier implements the alert.Notifier interface, and posts a message to
+// Slack for each alert that fires or is resolved.
+type Notifier struct {
+	Settings
+	client *http.Client
+
+	mu       sync.Mutex
+	channels map[string]*channel
+}
+
+// channel is the rate limiting state of a channel.
+type channel struct {
+	sent    []time.Time // within the last Interval, oldest first
+	pending []alert.Alert
+	flush   *time.Timer // set while alerts are pending
+}
+
+// New returns a pointer to a new Notifier.
+func New(settings Settings) (*Notifier, error) {
+	if settings.WebhookURL == "" {
+		return nil, fmt.Errorf("slack: webhook_url must be set")
+	}
+	if settings.MaxMessages <= 0 {
+		settings.MaxMessages = DefaultMaxMessages
+	}
+	if settings.Interval.Duration <= 0 {
+		settings.Interval.Duration = DefaultInterval
+	}
+	return &Notifier{
+		Settings: settings,
+		client:   &http.Client{Timeout: requestTimeout},
+		channels: make(map[string]*channel),
+	}, nil
+}
+
+// NewFromSettings is a convenience func that wraps New, and parses its
+// settings from the JSON object of a receiver.
+func NewFromSettings(raw json.RawMessage) (*Notifier, error) {
+	var s Settings
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("slack: %s", err)
+	}
+	return New(s)
+}
+
+type message struct {
+	Channel     string       `json:"channel,omitempty"`
+	Username    string       `json:"username,omitempty"`
+	IconEmoji   string       `json:"icon_emoji,omitempty"`
+	Text        string       `json:"text,omitempty"`
+	Attachments []attachment `json:"attachments,omitempty"`
+}
+
+type attachment struct {
+	Fallback  string   `json:"fallback"`
+	Color     string   `json:"color"`
+	Title     string   `json:"title"`
+	TitleLink string   `json:"title_link,omitempty"`
+	Text      string   `json:"text"`
+	Fields    []field  `json:"fields,omitempty"`
+	Timestamp int64    `json:"ts,omitempty"`
+	MrkdwnIn  []string `json:"mrkdwn_in,omitempty"`
+}
+
+type field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// Notify posts a message about the alert, unless its channel is over its
+// rate limit, in which case the alert is held for a summary.
+func (n *Notifier) Notify(a alert.Alert) error {
+	name := n.channelFor(a.Target)
+	now := time.Now()
+
+	n.mu.Lock()
+	c, ok := n.channels[name]
+	if !ok {
+		c = &channel{}
+		n.channels[name] = c
+	}
+	c.expire(now, n.Interval.Duration)
+	if c.flush != nil || len(c.sent) >= n.MaxMessages {
+		c.pending = append(c.pending, a)
+		if c.flush == nil {
+			wait := c.sent[0].Add(n.Interval.Duration).Sub(now)
+			c.flush = time.AfterFunc(wait, func() { n.flush(name) })
+		}
+		n.mu.Unlock()
+		return nil
+	}
+	c.sent = append(c.sent, now)
+	n.mu.Unlock()
+
+	return n.post(name, n.message(a))
+}
+
+// flush posts the alerts held for a channel: a single alert as usual, or
+// several as a summary.
+func (n *Notifier) flush(name string) {
+	n.mu.Lock()
+	c := n.channels[name]
+	pending := c.pending
+	c.pending, c.flush = nil, nil
+	c.sent = append(c.sent, time.Now())
+	n.mu.Unlock()
+
+	var m message
+	if len(pending) == 1 {
+		m = n.message(pending[0])
+	} else {
+		m = n.summary(pending)
+	}
+	if err := n.post(name, m); err != nil {
+		// there is no caller to return the error to
+		log.Printf("posting %d held alerts: %s", len(pending), err)
+	}
+}
+
+// expire forgets the messages sent before the last interval.
+func (c *channel) expire(now time.Time, interval time.Duration) {
+	i := 0
+	for i < len(c.sent) && now.Sub(c.sent[i]) >= interval {
+		i++
+	}
+	c.sent = c.sent[i:]
+}
+
+// channelFor returns the channel of a target's alerts, "" for the
+// webhook's own.
+func (n *Notifier) channelFor(t sampler.Target) string {
+	for _, tag := range t.Tags {
+		if c, ok := n.Channels[tag]; ok {
+			return c
+		}
+	}
+	return n.Channel
+}
+
+func (n *Notifier) post(channel string, m message) error {
+	m.Channel, m.Username, m.IconEmoji = channel, n.Username, n.IconEmoji
+	if err := alert.PostJSON(n.client, n.WebhookURL, nil, m); err != nil {
+		return fmt.Errorf("slack: %s", err)
+	}
+	return nil
+}
+
+// message describes an alert: the target, its error, the latency of the
+// last sample and, once resolved, how long the alert lasted.
+func (n *Notifier) message(a alert.Alert) message {
+	name := a.Target.Name
+	url := ""
+	if a.Target.URL.URL != nil {
+		url = a.Target.URL.String()
+		if name == "" {
+			name = url
+		}
+	}
+
+	att := attachment{
+		TitleLink: url,
+		Text:      escape(a.Summary),
+		Timestamp: a.StartsAt.Unix(),
+	}
+	if a.Status == alert.Firing {
+		att.Color = "danger"
+		att.Title = fmt.Sprintf(":red_circle: %s: %s", escape(a.Rule), escape(name))
+	} else {
+		att.Color = "good"
+		att.Title = fmt.Sprintf(":large_green_circle: Resolved %s: %s", escape(a.Rule), escape(name))
+		att.Timestamp = a.EndsAt.Unix()
+	}
+	att.Fallback = att.Title + " - " + att.Text
+
+	if url != "" {
+		att.Fields = append(att.Fields, field{"URL", escape(url), false})
+	}
+	if err := a.Measurement.Error; err != nil {
+		switch e := err.(type) {
+		case sampler.StatusCodeError:
+			att.Fields = append(att.Fields, field{"HTTP status", fmt.Sprint(e.StatusCode), true})
+		case *sampler.StatusCodeError:
+			att.Fields = append(att.Fields, field{"HTTP status", fmt.Sprint(e.StatusCode), true})
+		default:
+			att.Fields = append(att.Fields, field{"Transport error", escape(err.Error()), true})
+		}
+	}
+	if s := a.Measurement.Sample; !s.TimeStart.IsZero() && !s.TimeEnd.IsZero() {
+		att.Fields = append(att.Fields, field{"Latency", s.TimeEnd.Sub(s.TimeStart).String(), true})
+	}
+	if a.Status == alert.Resolved && !a.StartsAt.IsZero() {
+		att.Fields = append(att.Fields, field{"Lasted", a.EndsAt.Sub(a.StartsAt).Round(time.Second).String(), true})
+	}
+
+	return message{Attachments: []attachment{att}}
+}
+
+// summary lists several alerts in one message.
+func (n *Notifier) summary(alerts []alert.Alert) message {
+	firing := 0
+	var lines []string
+	for i, a := range alerts {
+		icon := ":large_green_circle:"
+		if a.Status == alert.Firing {
+			firing++
+			icon = ":red_circle:"
+		}
+		if i < maxSummaryLines {
+			lines = append(lines, fmt.Sprintf("%s *%s* %s", icon, escape(a.Target.Name), escape(a.Summary)))
+		}
+	}
+	if over := len(alerts) - maxSummaryLines; over > 0 {
+		lines = append(lines, fmt.Sprintf("and %d more", over))
+	}
+
+	title := fmt.Sprintf("%d alerts: %d firing, %d resolved", len(alerts), firing, len(alerts)-firing)
+	color := "good"
+	if firing > 0 {
+		color = "danger"
+	}
+	return message{Attachments: []attachment{{
+		Fallback: title,
+		Color:    color,
+		Title:    title,
+		Text:     strings.Join(lines, "\n"),
+		MrkdwnIn: []string{"text"},
+	}}}
+}
+
+// escape escapes the characters Slack treats as markup.
+func escape(s string) string {
+	s = strings.Replace(s, "&", "&amp;", -1)
+	s = strings.Replace(s, "<", "&lt;", -1)
+	return strings.Replace(s, ">", "&gt;", -1)
+}
@@ -0,0 +1,136 @@
+package slacknotifier
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/alert"
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+// webhook records the messages posted to it.
+type webhook struct {
+	mu       sync.Mutex
+	messages []message
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	var m message
+	json.NewDecoder(r.Body).Decode(&m)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.messages = append(w.messages, m)
+}
+
+func (w *webhook) posted() []message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]message(nil), w.messages...)
+}
+
+func newAlert(name string, status alert.Status, err error, tags ...string) alert.Alert {
+	u, _ := sampler.NewJsonURL("https://" + name + ".canary.io")
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	a := alert.Alert{
+		Rule:     "down",
+		Status:   status,
+		Target:   sampler.Target{URL: *u, Name: name, Tags: tags},
+		Summary:  name + " has been down for 3 consecutive samples",
+		StartsAt: t1,
+		Measurement: sensor.Measurement{
+			Sample: sampler.Sample{TimeStart: t1, TimeEnd: t1.Add(250 * time.Millisecond)},
+			Error:  err,
+		},
+	}
+	if status == alert.Resolved {
+		a.EndsAt = t1.Add(5 * time.Minute)
+	}
+	return a
+}
+
+func fields(m message) map[string]string {
+	f := make(map[string]string)
+	for _, field := range m.Attachments[0].Fields {
+		f[field.Title] = field.Value
+	}
+	return f
+}
+
+func TestMessage(t *testing.T) {
+	w := &webhook{}
+	ts := httptest.NewServer(w)
+	defer ts.Close()
+
+	n, err := NewFromSettings(json.RawMessage(`{"webhook_url": "` + ts.URL + `", "channel": "#ops", "channels": {"db": "#dba"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.Notify(newAlert("www", alert.Firing, &sampler.StatusCodeError{StatusCode: 503}))
+	n.Notify(newAlert("db", alert.Firing, errors.New("connecting: i/o timeout"), "prod", "db"))
+	n.Notify(newAlert("www", alert.Resolved, nil))
+
+	messages := w.posted()
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(messages))
+	}
+
+	down := messages[0]
+	if down.Channel != "#ops" || down.Attachments[0].Color != "danger" || down.Attachments[0].Title != ":red_circle: down: www" {
+		t.Errorf("unexpected firing message: %+v", down)
+	}
+	if f := fields(down); f["URL"] != "https://www.canary.io" || f["HTTP status"] != "503" || f["Latency"] != "250ms" {
+		t.Errorf("unexpected fields: %v", f)
+	}
+
+	if db := messages[1]; db.Channel != "#dba" || fields(db)["Transport error"] != "connecting: i/o timeout" {
+		t.Errorf("expected the db alert in #dba, with its transport error, got %+v", db)
+	}
+
+	up := messages[2]
+	if up.Attachments[0].Color != "good" || fields(up)["Lasted"] != "5m0s" {
+		t.Errorf("expected a resolved message with the duration of the alert, got %+v", up)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	w := &webhook{}
+	ts := httptest.NewServer(w)
+	defer ts.Close()
+
+	n, err := New(Settings{
+		WebhookURL:  ts.URL,
+		Channels:    map[string]string{"web": "#web"},
+		MaxMessages: 2,
+		Interval:    alert.Duration{Duration: 100 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		n.Notify(newAlert(name, alert.Firing, nil, "web"))
+	}
+	// another channel has a limit of its own
+	n.Notify(newAlert("api", alert.Firing, nil))
+
+	if messages := w.posted(); len(messages) != 3 {
+		t.Fatalf("expected 3 messages before the interval ends, got %d", len(messages))
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	messages := w.posted()
+	if len(messages) != 4 {
+		t.Fatalf("expected a summary once the interval ended, got %d messages", len(messages))
+	}
+	summary := messages[3]
+	if summary.Channel != "#web" || summary.Attachments[0].Title != "3 alerts: 3 firing, 0 resolved" {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
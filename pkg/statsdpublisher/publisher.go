@@ -0,0 +1,207 @@
+package statsdpublisher
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+const (
+	// DefaultAddr is the address of the StatsD agent if STATSD_ADDR is unset.
+	DefaultAddr = "127.0.0.1:8125"
+
+	// DefaultPrefix is prepended to every metric if STATSD_PREFIX is unset.
+	DefaultPrefix = "canary."
+
+	// DefaultMTU bounds the size of packets if STATSD_MTU is unset.  It
+	// leaves room for IP and UDP headers in a 1500 byte Ethernet frame.
+	DefaultMTU = 1432
+
+	// FlushInterval is how long metrics may wait for a packet to fill.
+	FlushInterval = time.Second
+)
+
+// Publisher implements the canary.Publisher interface, and sends
+// measurements to a StatsD agent as timing, counter and gauge metrics.
+// Metrics are batched into packets of up to MTU bytes.
+type Publisher struct {
+	Prefix    string
+	DogStatsD bool // add Target.Tags to every metric, DogStatsD style
+	MTU       int
+
+	conn net.Conn
+	stop chan struct{}
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// New returns a pointer to a new Publisher sending to the StatsD agent at
+// addr.  Buffered metrics are flushed every FlushInterval.
+func New(addr, prefix string, dogStatsD bool, mtu int) (*Publisher, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Publisher{
+		Prefix:    prefix,
+		DogStatsD: dogStatsD,
+		MTU:       mtu,
+		conn:      conn,
+		stop:      make(chan struct{}),
+	}
+	go p.flushPeriodically()
+	return p, nil
+}
+
+// NewFromEnv is a convenience func that wraps New, and populates its
+// arguments via environment variables.
+func NewFromEnv() (*Publisher, error) {
+	addr := os.Getenv("STATSD_ADDR")
+	if addr == "" {
+		addr = DefaultAddr
+	}
+
+	prefix, ok := os.LookupEnv("STATSD_PREFIX")
+	if !ok {
+		prefix = DefaultPrefix
+	}
+
+	mtu := DefaultMTU
+	if s := os.Getenv("STATSD_MTU"); s != "" {
+		var err error
+		mtu, err = strconv.Atoi(s)
+		if err != nil || mtu <= 0 {
+			return nil, fmt.Errorf("STATSD_MTU is not a valid positive integer")
+		}
+	}
+
+	return New(addr, prefix, os.Getenv("STATSD_DOGSTATSD") == "yes", mtu)
+}
+
+// Publish takes a canary.Measurement and buffers its metrics, sending a
+// packet whenever the buffer fills up.  The up gauge follows IsOK, the
+// confirmed state of the target.
+func (p *Publisher) Publish(m sensor.Measurement) (err error) {
+	name := p.Prefix + sanitize(m.Target.Name)
+	suffix := ""
+	if p.DogStatsD && len(m.Target.Tags) > 0 {
+		tags := make([]string, len(m.Target.Tags))
+		for i, tag := range m.Target.Tags {
+			tags[i] = sanitizeTag(tag)
+		}
+		suffix = "|#" + strings.Join(tags, ",")
+	}
+
+	var lines []string
+	add := func(metric, value, kind string) {
+		lines = append(lines, name+metric+":"+value+"|"+kind+suffix)
+	}
+
+	if !m.Sample.TimeStart.IsZero() && !m.Sample.TimeEnd.IsZero() {
+		latency := m.Sample.TimeEnd.Sub(m.Sample.TimeStart).Seconds() * 1000
+		add(".latency", strconv.FormatFloat(latency, 'f', -1, 64), "ms")
+	}
+	add(".samples", "1", "c")
+	if m.InMaintenance {
+		add(".maintenance", "1", "c")
+	} else if m.Error != nil {
+		add(".errors", "1", "c")
+		switch m.Error.(type) {
+		case sampler.StatusCodeError, *sampler.StatusCodeError:
+			add(".errors.http", "1", "c")
+		default:
+			add(".errors.sampler", "1", "c")
+		}
+	}
+	if !m.InMaintenance {
+		up := "0"
+		if m.IsOK {
+			up = "1"
+		}
+		add(".up", up, "g")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, line := range lines {
+		if len(p.buf) > 0 && len(p.buf)+1+len(line) > p.MTU {
+			if e := p.flush(); e != nil {
+				err = e
+			}
+		}
+		if len(p.buf) > 0 {
+			p.buf = append(p.buf, '\n')
+		}
+		p.buf = append(p.buf, line...)
+	}
+	return
+}
+
+// Flush sends any buffered metrics.
+func (p *Publisher) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.flush()
+}
+
+// Close flushes any buffered metrics, and stops the publisher.
+func (p *Publisher) Close() error {
+	close(p.stop)
+	err := p.Flush()
+	p.conn.Close()
+	return err
+}
+
+// flush sends the buffer as a single packet.  mu must be held.
+func (p *Publisher) flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	_, err := p.conn.Write(p.buf)
+	p.buf = p.buf[:0]
+	return err
+}
+
+func (p *Publisher) flushPeriodically() {
+	t := time.NewTicker(FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.Flush()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// sanitize replaces the characters StatsD gives meaning to in metric names.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// sanitizeTag replaces the characters DogStatsD gives meaning to in tags.
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', ',', '#', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,105 @@
+package statsdpublisher
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+func listen(t *testing.T) net.PacketConn {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func receive(t *testing.T, l net.PacketConn) string {
+	buf := make([]byte, 65536)
+	l.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := l.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func measurement(name string, err error) sensor.Measurement {
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	return sensor.Measurement{
+		Target: sampler.Target{Name: name, Tags: []string{"env:prod", "web"}},
+		Sample: sampler.Sample{
+			TimeStart: t1,
+			TimeEnd:   t1.Add(250 * time.Millisecond),
+		},
+		IsOK:  err == nil,
+		Error: err,
+	}
+}
+
+func TestPublish(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	p, err := New(l.LocalAddr().String(), "canary.", true, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Publish(measurement("www", nil))
+	p.Publish(measurement("api:v1", &sampler.StatusCodeError{StatusCode: 503}))
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"canary.www.latency:250|ms|#env:prod,web",
+		"canary.www.samples:1|c|#env:prod,web",
+		"canary.www.up:1|g|#env:prod,web",
+		"canary.api_v1.latency:250|ms|#env:prod,web",
+		"canary.api_v1.samples:1|c|#env:prod,web",
+		"canary.api_v1.errors:1|c|#env:prod,web",
+		"canary.api_v1.errors.http:1|c|#env:prod,web",
+		"canary.api_v1.up:0|g|#env:prod,web",
+	}, "\n")
+	if packet := receive(t, l); packet != expected {
+		t.Fatalf("expected packet:\n%s\nbut got:\n%s", expected, packet)
+	}
+}
+
+func TestPublishBatchesUpToMTU(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	mtu := 100
+	p, err := New(l.LocalAddr().String(), "canary.", false, mtu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for i := 0; i < 5; i++ {
+		p.Publish(measurement("www", nil))
+	}
+	p.Flush()
+
+	var lines []string
+	for len(lines) < 15 {
+		packet := receive(t, l)
+		if len(packet) > mtu {
+			t.Fatalf("expected packets of at most %d bytes, got %d", mtu, len(packet))
+		}
+		if strings.Contains(packet, "|#") {
+			t.Fatalf("expected no tags without DogStatsD, got %s", packet)
+		}
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	if len(lines) != 15 {
+		t.Fatalf("expected 15 metrics, got %d", len(lines))
+	}
+}
@@ -1,30 +1,92 @@
 package stdoutpublisher
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/canaryio/canary/pkg/sampler"
 	"github.com/canaryio/canary/pkg/sensor"
 )
 
+// Format is how measurements are written.
+type Format string
+
+const (
+	// Text is a space separated line, for reading in a terminal.
+	Text Format = "text"
+	// JSON is a JSON object per line.
+	JSON Format = "json"
+	// Logfmt is a line of key=value pairs.
+	Logfmt Format = "logfmt"
+)
+
 // Publisher implements canary.Publisher, and is our
 // gateway for delivering canary.Measurement data to STDOUT.
-type Publisher struct{}
+type Publisher struct {
+	Format Format
+	Writer io.Writer
 
-// New returns a pointer to a new Publsher.
+	mu sync.Mutex // serializes writes, so that lines are not interleaved
+}
+
+// New returns a pointer to a new Publsher, writing text to STDOUT.
 func New() *Publisher {
-	return &Publisher{}
+	return &Publisher{Format: Text, Writer: os.Stdout}
+}
+
+// NewFromEnv is a convenience func that wraps New, and sets the format
+// from STDOUT_FORMAT.
+func NewFromEnv() (*Publisher, error) {
+	p := New()
+	switch format := Format(os.Getenv("STDOUT_FORMAT")); format {
+	case "":
+	case Text, JSON, Logfmt:
+		p.Format = format
+	default:
+		return nil, fmt.Errorf("unknown STDOUT_FORMAT %q, must be text, json or logfmt", format)
+	}
+	return p, nil
 }
 
 // Publish takes a canary.Measurement and emits data to STDOUT.
 func (p *Publisher) Publish(m sensor.Measurement) (err error) {
+	var line []byte
+	switch p.Format {
+	case JSON:
+		line, err = json.Marshal(newRecord(m))
+		if err != nil {
+			return
+		}
+	case Logfmt:
+		line = newRecord(m).logfmt()
+	default:
+		line = text(m)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.Writer.Write(append(line, '\n'))
+	return
+}
+
+// text renders the measurement as a space separated line.  Its boolean is
+// the confirmed state of the target, as in the structured formats' ok.
+func text(m sensor.Measurement) []byte {
 	errMessage := ``
 	if m.Error != nil {
 		errMessage = fmt.Sprintf("'%s'", m.Error)
 	}
 
-	fmt.Printf(
-		"%s %s %d %f %t %d %s\n",
+	return []byte(fmt.Sprintf(
+		"%s %s %d %f %t %d %s",
 		m.Sample.TimeEnd.Format(time.RFC3339),
 		m.Target.URL,
 		m.Sample.StatusCode,
@@ -32,6 +94,183 @@ func (p *Publisher) Publish(m sensor.Measurement) (err error) {
 		m.IsOK,
 		m.StateCount,
 		errMessage,
-	)
-	return
+	))
+}
+
+// record is the whole of a measurement, as written in the structured
+// formats.  Each timestamp of the sample is a duration in milliseconds since
+// its start, and is omitted if the sample did not get that far.  OK is the
+// confirmed state of the target, and SampleOK the result of this sample.
+type record struct {
+	Time       string            `json:"time"`
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	Tags       []string          `json:"tags,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Interval   int               `json:"interval,omitempty"`
+	Hash       string            `json:"hash,omitempty"`
+
+	Start        string   `json:"start,omitempty"`
+	ResolveIPMs  *float64 `json:"resolve_ip_ms,omitempty"`
+	TCPConnectMs *float64 `json:"tcp_connect_ms,omitempty"`
+	ConnectMs    *float64 `json:"connect_ms,omitempty"`
+	FirstByteMs  *float64 `json:"first_byte_ms,omitempty"`
+	TotalMs      *float64 `json:"total_ms,omitempty"`
+	StatusCode   int      `json:"status_code,omitempty"`
+	LocalIP      string   `json:"local_ip,omitempty"`
+	RemoteIP     string   `json:"remote_ip,omitempty"`
+	TLSNotAfter  string   `json:"tls_not_after,omitempty"`
+	TraceID      string   `json:"trace_id,omitempty"`
+	OK           bool     `json:"ok"`
+	StateCount   int      `json:"state_count"`
+	SampleOK     bool     `json:"sample_ok"`
+	Retried      bool     `json:"retried,omitempty"`
+	Flapping     bool     `json:"flapping,omitempty"`
+	Maintenance  bool     `json:"in_maintenance,omitempty"`
+	ErrorClass   string   `json:"error_class,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+func newRecord(m sensor.Measurement) record {
+	s := m.Sample
+	r := record{
+		Name:        m.Target.Name,
+		URL:         m.Target.URL.String(),
+		Tags:        m.Target.Tags,
+		Attributes:  m.Target.Attributes,
+		Interval:    m.Target.Interval,
+		Hash:        m.Target.Hash,
+		StatusCode:  s.StatusCode,
+		TraceID:     s.TraceID,
+		OK:          m.IsOK,
+		StateCount:  m.StateCount,
+		SampleOK:    m.SampleOK,
+		Retried:     m.Retried,
+		Flapping:    m.Flapping,
+		Maintenance: m.InMaintenance,
+	}
+
+	// samples that fail before they start have no times
+	end := s.TimeEnd
+	if end.IsZero() {
+		end = time.Now()
+	}
+	r.Time = end.UTC().Format(time.RFC3339Nano)
+
+	if !s.TimeStart.IsZero() {
+		r.Start = s.TimeStart.UTC().Format(time.RFC3339Nano)
+		since := func(t time.Time) *float64 {
+			if t.IsZero() {
+				return nil
+			}
+			ms := t.Sub(s.TimeStart).Seconds() * 1000
+			return &ms
+		}
+		r.ResolveIPMs = since(s.TimeToResolveIP)
+		r.TCPConnectMs = since(s.TimeToTCPConnect)
+		r.ConnectMs = since(s.TimeToConnect)
+		r.FirstByteMs = since(s.TimeToFirstByte)
+		r.TotalMs = since(s.TimeEnd)
+	}
+	if s.LocalAddr != nil {
+		r.LocalIP = s.LocalAddr.String()
+	}
+	if s.RemoteAddr != nil {
+		r.RemoteIP = s.RemoteAddr.String()
+	}
+	if !s.TLSNotAfter.IsZero() {
+		r.TLSNotAfter = s.TLSNotAfter.UTC().Format(time.RFC3339)
+	}
+
+	if m.Error != nil {
+		r.Error = m.Error.Error()
+		switch m.Error.(type) {
+		case sampler.StatusCodeError, *sampler.StatusCodeError:
+			r.ErrorClass = "http"
+		default:
+			r.ErrorClass = "sampler"
+		}
+	}
+	return r
+}
+
+// logfmt renders the record as key=value pairs, in the order of its
+// fields.  Tags are comma separated, and each attribute is an attr.<key>
+// pair.
+func (r record) logfmt() []byte {
+	var b bytes.Buffer
+	pair := func(key, value string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key + "=" + logfmtValue(value))
+	}
+	optional := func(key, value string) {
+		if value != "" {
+			pair(key, value)
+		}
+	}
+	ms := func(key string, v *float64) {
+		if v != nil {
+			pair(key, strconv.FormatFloat(*v, 'f', -1, 64))
+		}
+	}
+
+	pair("time", r.Time)
+	pair("name", r.Name)
+	pair("url", r.URL)
+	optional("tags", strings.Join(r.Tags, ","))
+	keys := make([]string, 0, len(r.Attributes))
+	for k := range r.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pair("attr."+k, r.Attributes[k])
+	}
+	if r.Interval != 0 {
+		pair("interval", strconv.Itoa(r.Interval))
+	}
+	optional("hash", r.Hash)
+
+	optional("start", r.Start)
+	ms("resolve_ip_ms", r.ResolveIPMs)
+	ms("tcp_connect_ms", r.TCPConnectMs)
+	ms("connect_ms", r.ConnectMs)
+	ms("first_byte_ms", r.FirstByteMs)
+	ms("total_ms", r.TotalMs)
+	if r.StatusCode != 0 {
+		pair("status_code", strconv.Itoa(r.StatusCode))
+	}
+	optional("local_ip", r.LocalIP)
+	optional("remote_ip", r.RemoteIP)
+	optional("tls_not_after", r.TLSNotAfter)
+	optional("trace_id", r.TraceID)
+	pair("ok", strconv.FormatBool(r.OK))
+	pair("state_count", strconv.Itoa(r.StateCount))
+	pair("sample_ok", strconv.FormatBool(r.SampleOK))
+	if r.Retried {
+		pair("retried", "true")
+	}
+	if r.Flapping {
+		pair("flapping", "true")
+	}
+	if r.Maintenance {
+		pair("in_maintenance", "true")
+	}
+	optional("error_class", r.ErrorClass)
+	optional("error", r.Error)
+
+	return b.Bytes()
+}
+
+// logfmtValue quotes v if it is empty or contains spaces, quotes, '=' or
+// control characters.
+func logfmtValue(v string) string {
+	if v == "" || strings.IndexFunc(v, func(r rune) bool {
+		return r <= ' ' || r == '"' || r == '=' || r == '\\' || r == 0x7f
+	}) >= 0 {
+		return strconv.Quote(v)
+	}
+	return v
 }
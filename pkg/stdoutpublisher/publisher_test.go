@@ -1,6 +1,9 @@
 package stdoutpublisher
 
 import (
+	"bytes"
+	"net"
+	"testing"
 	"time"
 
 	"github.com/canaryio/canary/pkg/sampler"
@@ -32,3 +35,58 @@ func ExamplePublisher_Publish() {
 	// Output:
 	// 2014-12-28T00:00:01Z http://www.canary.io 200 1000.000000 true 2
 }
+
+func measurement() sensor.Measurement {
+	url, _ := sampler.NewJsonURL("https://www.canary.io/status")
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	return sensor.Measurement{
+		Target: sampler.Target{
+			URL:        *url,
+			Name:       "www",
+			Tags:       []string{"web", "prod"},
+			Attributes: map[string]string{"team": "site ops"},
+		},
+		Sample: sampler.Sample{
+			StatusCode:      503,
+			TimeStart:       t1,
+			TimeToResolveIP: t1.Add(10 * time.Millisecond),
+			TimeToConnect:   t1.Add(30 * time.Millisecond),
+			TimeToFirstByte: t1.Add(130 * time.Millisecond),
+			TimeEnd:         t1.Add(150 * time.Millisecond),
+			RemoteAddr:      net.ParseIP("192.0.2.1"),
+		},
+		// a failure, retried, that is not confirmed yet
+		IsOK:       true,
+		StateCount: 3,
+		Retried:    true,
+		Error:      &sampler.StatusCodeError{StatusCode: 503},
+	}
+}
+
+func TestPublishJSON(t *testing.T) {
+	var b bytes.Buffer
+	p := &Publisher{Format: JSON, Writer: &b}
+	p.Publish(measurement())
+
+	expected := `{"time":"2014-12-28T00:00:00.15Z","name":"www","url":"https://www.canary.io/status",` +
+		`"tags":["web","prod"],"attributes":{"team":"site ops"},"start":"2014-12-28T00:00:00Z",` +
+		`"resolve_ip_ms":10,"connect_ms":30,"first_byte_ms":130,"total_ms":150,"status_code":503,` +
+		`"remote_ip":"192.0.2.1","ok":true,"state_count":3,"sample_ok":false,"retried":true,"error_class":"http","error":"recieved HTTP status 503"}` + "\n"
+	if b.String() != expected {
+		t.Fatalf("expected:\n%s\nbut got:\n%s", expected, b.String())
+	}
+}
+
+func TestPublishLogfmt(t *testing.T) {
+	var b bytes.Buffer
+	p := &Publisher{Format: Logfmt, Writer: &b}
+	p.Publish(measurement())
+
+	expected := `time=2014-12-28T00:00:00.15Z name=www url=https://www.canary.io/status tags=web,prod ` +
+		`attr.team="site ops" start=2014-12-28T00:00:00Z resolve_ip_ms=10 connect_ms=30 first_byte_ms=130 ` +
+		`total_ms=150 status_code=503 remote_ip=192.0.2.1 ok=true state_count=3 sample_ok=false retried=true ` +
+		`error_class=http error="recieved HTTP status 503"` + "\n"
+	if b.String() != expected {
+		t.Fatalf("expected:\n%s\nbut got:\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,353 @@
+package webhookpublisher
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+const (
+	// URLAttribute is the target attribute that overrides the URL events
+	// about that target are posted to.
+	URLAttribute = "webhook_url"
+
+	// SignatureHeader carries the HMAC-SHA256 of the payload, hex encoded
+	// and prefixed with "sha256=", if a secret is configured.
+	SignatureHeader = "X-Canary-Signature"
+
+	// MaxRetries is how many times a failed delivery is retried.
+	MaxRetries = 3
+
+	// queueSize bounds the events waiting to be delivered.
+	queueSize = 1000
+
+	postTimeout = 10 * time.Second
+)
+
+// retryDelay is the delay before the first retry, doubling for each retry.
+var retryDelay = time.Second
+
+// DefaultTemplate renders an Event as a JSON object.
+const DefaultTemplate = `{
+  "event": {{json .Event}},
+  "state": {{json .State}},
+  "previous_state": {{json .PreviousState}},
+  "previous_state_seconds": {{json .PreviousStateDuration.Seconds}},
+  "state_count": {{.StateCount}},
+  "time": {{json .Time}},
+  "target": {
+    "name": {{json .Target.Name}},
+    "url": {{json .Target.URL.String}},
+    "tags": {{json .Target.Tags}},
+    "attributes": {{json .Target.Attributes}}
+  },
+  "status_code": {{.StatusCode}},
+  "error_class": {{json .ErrorClass}},
+  "error": {{json .Error}}
+}`
+
+// Event types.
+const (
+	// Changed is sent when a target goes up or down.
+	Changed = "changed"
+	// Threshold is sent once a target has been down for Threshold
+	// samples.
+	Threshold = "threshold"
+)
+
+// Event is a change in the state of a target, as passed to the template.
+type Event struct {
+	Event      string // Changed or Threshold
+	State      string // "up" or "down"
+	StateCount int
+	Time       time.Time
+	Target     sampler.Target
+
+	// the state before the current one, "unknown" for a target's first
+	// state, and how long it lasted
+	PreviousState         string
+	PreviousStateDuration time.Duration
+
+	// the last error, if the target is down
+	StatusCode int
+	ErrorClass string // "http" or "sampler"
+	Error      string
+}
+
+// Publisher implements the canary.Publisher interface, and posts an Event
+// to a webhook whenever a target changes state, or has been down for
+// Threshold samples.  Events are delivered in order in the background,
+// with retries.
+type Publisher struct {
+	URL       string // used for targets without the URLAttribute
+	Secret    string // signs payloads if set
+	Threshold int    // send a Threshold event after this many samples down, if set
+
+	template *template.Template
+	client   *http.Client
+
+	mu      sync.Mutex
+	targets map[string]*targetState // by target name
+
+	queue chan delivery
+	done  chan struct{}
+}
+
+type targetState struct {
+	ok        bool
+	since     time.Time
+	previous  string        // state before the current one
+	lasted    time.Duration // how long the previous state lasted
+	threshold bool          // whether the Threshold event was sent
+}
+
+type delivery struct {
+	url  string
+	body []byte
+}
+
+// New returns a pointer to a new Publisher posting to url, rendering events
+// with tmpl, or DefaultTemplate if it is empty.
+func New(url, secret string, threshold int, tmpl string) (*Publisher, error) {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	t, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Publisher{
+		URL:       url,
+		Secret:    secret,
+		Threshold: threshold,
+		template:  t,
+		client:    &http.Client{Timeout: postTimeout},
+		targets:   make(map[string]*targetState),
+		queue:     make(chan delivery, queueSize),
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// NewFromEnv is a convenience func that wraps New, and populates its
+// arguments via environment variables.
+func NewFromEnv() (*Publisher, error) {
+	threshold := 0
+	if s := os.Getenv("WEBHOOK_THRESHOLD"); s != "" {
+		var err error
+		threshold, err = strconv.Atoi(s)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("WEBHOOK_THRESHOLD is not a valid positive integer")
+		}
+	}
+
+	tmpl := ""
+	if path := os.Getenv("WEBHOOK_TEMPLATE"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("WEBHOOK_TEMPLATE: %s", err)
+		}
+		tmpl = string(b)
+	}
+
+	return New(os.Getenv("WEBHOOK_URL"), os.Getenv("WEBHOOK_SECRET"), threshold, tmpl)
+}
+
+// Publish takes a canary.Measurement, and queues an event for delivery if
+// it changed the state of its target or crossed the threshold.
+// Measurements in maintenance are ignored.
+func (p *Publisher) Publish(m sensor.Measurement) (err error) {
+	if m.InMaintenance {
+		return
+	}
+	url := p.URL
+	if u := m.Target.Attributes[URLAttribute]; u != "" {
+		url = u
+	}
+	if url == "" {
+		return
+	}
+
+	e, ok := p.event(m)
+	if !ok {
+		return
+	}
+
+	var b bytes.Buffer
+	if err = p.template.Execute(&b, e); err != nil {
+		return fmt.Errorf("webhook: rendering payload: %s", err)
+	}
+	if !json.Valid(b.Bytes()) {
+		return fmt.Errorf("webhook: template did not render valid JSON: %s", b.String())
+	}
+
+	select {
+	case p.queue <- delivery{url, b.Bytes()}:
+	default:
+		err = fmt.Errorf("webhook: queue full, dropping %s event for %s", e.Event, m.Target.Name)
+	}
+	return
+}
+
+// event updates the state of the measurement's target, and returns the
+// event it causes, if any.
+func (p *Publisher) event(m sensor.Measurement) (Event, bool) {
+	now := m.Sample.TimeStart
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, known := p.targets[m.Target.Name]
+	changed := !known || s.ok != m.IsOK
+	if changed {
+		if !known {
+			s = &targetState{previous: "unknown"}
+			p.targets[m.Target.Name] = s
+		} else {
+			s.previous = state(s.ok)
+			s.lasted = now.Sub(s.since)
+		}
+		s.ok = m.IsOK
+		s.since = now
+		s.threshold = false
+	}
+
+	e := Event{
+		State:                 state(m.IsOK),
+		StateCount:            m.StateCount,
+		Time:                  now,
+		Target:                m.Target,
+		PreviousState:         s.previous,
+		PreviousStateDuration: s.lasted,
+		StatusCode:            m.Sample.StatusCode,
+	}
+	if m.Error != nil {
+		e.Error = m.Error.Error()
+		switch m.Error.(type) {
+		case sampler.StatusCodeError, *sampler.StatusCodeError:
+			e.ErrorClass = "http"
+		default:
+			e.ErrorClass = "sampler"
+		}
+	}
+
+	switch {
+	// a target that is up from the start has not changed
+	case changed && (known || !m.IsOK):
+		e.Event = Changed
+	// the count may skip past the threshold as failures are confirmed, in
+	// which case the event follows on the next sample
+	case p.Threshold > 1 && !m.IsOK && !s.threshold && m.StateCount >= p.Threshold:
+		s.threshold = true
+		e.Event = Threshold
+	default:
+		return e, false
+	}
+	return e, true
+}
+
+// RemoveTarget forgets the state of a target that is no longer monitored.
+func (p *Publisher) RemoveTarget(t sampler.Target) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.targets, t.Name)
+}
+
+// ReplaceTarget keeps the state of a target whose settings changed, as it
+// is kept by name, so the change sends no event.
+func (p *Publisher) ReplaceTarget(old, changed sampler.Target) {}
+
+// Close delivers the events already queued, and stops the publisher.
+func (p *Publisher) Close() {
+	close(p.queue)
+	<-p.done
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+	for d := range p.queue {
+		if err := p.deliver(d); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// deliver posts a payload, retrying failures with backoff.
+func (p *Publisher) deliver(d delivery) (err error) {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err = p.post(d)
+		if err == nil {
+			return nil
+		}
+		if attempt == MaxRetries {
+			return fmt.Errorf("webhook: giving up on %s: %s", d.url, err)
+		}
+
+		log.Printf("webhook: posting to %s failed, retrying in %s: %s", d.url, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (p *Publisher) post(d delivery) error {
+	req, err := http.NewRequest("POST", d.url, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(p.Secret, d.body))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("received HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature of a payload, as sent in SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func state(ok bool) string {
+	if ok {
+		return "up"
+	}
+	return "down"
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
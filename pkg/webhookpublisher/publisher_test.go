@@ -0,0 +1,188 @@
+package webhookpublisher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
+
+func init() {
+	retryDelay = time.Millisecond
+}
+
+// receiver records the payloads posted to it, failing the first fail posts.
+type receiver struct {
+	mu         sync.Mutex
+	fail       int
+	payloads   []map[string]interface{}
+	signatures []string // as received
+	expected   []string // as computed from the payload
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail > 0 {
+		r.fail--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.payloads = append(r.payloads, payload)
+	r.signatures = append(r.signatures, req.Header.Get(SignatureHeader))
+	r.expected = append(r.expected, Sign("secret", body))
+}
+
+func measurement(count int, err error, at time.Duration) sensor.Measurement {
+	u, _ := sampler.NewJsonURL("http://www.canary.io")
+	t1, _ := time.Parse(time.RFC3339, "2014-12-28T00:00:00Z")
+	return sensor.Measurement{
+		Target: sampler.Target{URL: *u, Name: "www", Hash: "www"},
+		Sample: sampler.Sample{
+			TimeStart: t1.Add(at),
+			TimeEnd:   t1.Add(at + 100*time.Millisecond),
+		},
+		IsOK:       err == nil,
+		StateCount: count,
+		Error:      err,
+	}
+}
+
+func TestPublishStateChanges(t *testing.T) {
+	r := &receiver{fail: 1}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	p, err := New(ts.URL, "secret", 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down := &sampler.StatusCodeError{StatusCode: 503}
+	for _, m := range []sensor.Measurement{
+		measurement(1, nil, 0), // up from the start, no event
+		measurement(2, nil, time.Minute),
+		measurement(3, nil, 2*time.Minute), // up past the threshold, no event
+		measurement(4, nil, 3*time.Minute),
+		measurement(1, down, 4*time.Minute), // changed
+		measurement(2, down, 5*time.Minute),
+		measurement(3, down, 6*time.Minute), // threshold
+		measurement(4, down, 7*time.Minute),
+		measurement(1, nil, 8*time.Minute), // changed
+		measurement(2, nil, 9*time.Minute),
+		measurement(3, nil, 10*time.Minute),
+	} {
+		if err := p.Publish(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Close()
+
+	if len(r.payloads) != 3 {
+		t.Fatalf("expected 3 events, got %d: %v", len(r.payloads), r.payloads)
+	}
+	for i, e := range []struct {
+		event, state, previous string
+		seconds                float64
+	}{
+		{Changed, "down", "up", 240},
+		{Threshold, "down", "up", 240},
+		{Changed, "up", "down", 240},
+	} {
+		got := r.payloads[i]
+		if got["event"] != e.event || got["state"] != e.state || got["previous_state"] != e.previous || got["previous_state_seconds"] != e.seconds {
+			t.Errorf("expected event %d to be %+v, got %v", i, e, got)
+		}
+	}
+	if got := r.payloads[0]; got["error_class"] != "http" || got["error"] != "recieved HTTP status 503" {
+		t.Errorf("expected the error to be included, got %v", got)
+	}
+	if target := r.payloads[0]["target"].(map[string]interface{}); target["name"] != "www" || target["url"] != "http://www.canary.io" {
+		t.Errorf("expected the target to be included, got %v", target)
+	}
+	for i, s := range r.signatures {
+		if s != r.expected[i] {
+			t.Errorf("expected the payload to be signed with %s, got %q", r.expected[i], s)
+		}
+	}
+}
+
+func TestThresholdSkippedByConfirmation(t *testing.T) {
+	r := &receiver{}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	p, err := New(ts.URL, "", 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with failures confirmed after 4 samples, the count starts at 4
+	down := &sampler.StatusCodeError{StatusCode: 503}
+	for i, count := range []int{4, 5, 6} {
+		p.Publish(measurement(count, down, time.Duration(i)*time.Minute))
+	}
+	p.Close()
+
+	if len(r.payloads) != 2 || r.payloads[0]["event"] != Changed || r.payloads[1]["event"] != Threshold {
+		t.Fatalf("expected a changed then a threshold event, got %v", r.payloads)
+	}
+}
+
+func TestReplaceTarget(t *testing.T) {
+	r := &receiver{}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	p, err := New(ts.URL, "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down := &sampler.StatusCodeError{StatusCode: 503}
+	old := measurement(1, down, 0)
+	p.Publish(old)
+
+	changed := measurement(2, down, time.Minute)
+	changed.Target.Hash = "www-changed"
+	p.ReplaceTarget(old.Target, changed.Target)
+	p.Publish(changed)
+	p.Close()
+
+	if len(r.payloads) != 1 {
+		t.Fatalf("expected changing the settings of a down target to send no event, got %v", r.payloads)
+	}
+}
+
+func TestPublishToTargetURL(t *testing.T) {
+	def, override := &receiver{}, &receiver{}
+	defTS, overrideTS := httptest.NewServer(def), httptest.NewServer(override)
+	defer defTS.Close()
+	defer overrideTS.Close()
+
+	p, err := New(defTS.URL, "", 0, `{"text": {{json .Target.Name}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := measurement(1, &sampler.StatusCodeError{StatusCode: 500}, 0)
+	m.Target.Attributes = map[string]string{URLAttribute: overrideTS.URL}
+	p.Publish(m)
+	p.Close()
+
+	if len(def.payloads) != 0 || len(override.payloads) != 1 || override.payloads[0]["text"] != "www" {
+		t.Fatalf("expected the templated event to be posted to the target's URL only, got %v and %v", def.payloads, override.payloads)
+	}
+}
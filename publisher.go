@@ -1,6 +1,9 @@
 package canary
 
-import "github.com/canaryio/canary/pkg/sensor"
+import (
+	"github.com/canaryio/canary/pkg/sampler"
+	"github.com/canaryio/canary/pkg/sensor"
+)
 
 // Publisher is the interface that adds the Publish method.
 //
@@ -9,3 +12,20 @@ import "github.com/canaryio/canary/pkg/sensor"
 type Publisher interface {
 	Publish(sensor.Measurement) error
 }
+
+// TargetRemover is implemented by publishers that keep state for each
+// target.  RemoveTarget is called once a reload has stopped the sensor of a
+// target, so that its state can be dropped.  It is called from the
+// goroutine that publishes measurements, after the last measurement of the
+// stopped sensor.
+type TargetRemover interface {
+	RemoveTarget(sampler.Target)
+}
+
+// TargetReplacer is implemented by publishers that follow targets by name.
+// When a reload changes the settings of a target, ReplaceTarget is called
+// with the old and changed targets rather than RemoveTarget, at the same
+// point.
+type TargetReplacer interface {
+	ReplaceTarget(old, changed sampler.Target)
+}
@@ -0,0 +1,125 @@
+package canary
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// targetStatus describes the current state of a target's sensor.
+type targetStatus struct {
+	Name  string   `json:"name"`
+	URL   string   `json:"url"`
+	Tags  []string `json:"tags"`
+	State string   `json:"state"` // "up", "down", or "unknown" until sampled
+
+	Flapping       bool       `json:"flapping"`
+	InMaintenance  bool       `json:"inMaintenance"`
+	Paused         bool       `json:"paused"`
+	Since          *time.Time `json:"since,omitempty"`
+	InStateSeconds float64    `json:"inStateSeconds"`
+	LastSample     *time.Time `json:"lastSample,omitempty"`
+	LastLatencyMs  float64    `json:"lastLatencyMs"`
+	LastError      string     `json:"lastError,omitempty"`
+}
+
+// status returns the state of every running sensor, in manifest order.
+func (c *Canary) status() []targetStatus {
+	now := time.Now()
+	targets := []targetStatus{}
+	for _, s := range c.sensors() {
+		state := s.State()
+		ts := targetStatus{
+			Name:     s.Target.Name,
+			URL:      s.Target.URL.String(),
+			Tags:     s.Target.Tags,
+			State:    "unknown",
+			Flapping: state.Flapping,
+			Paused:   state.Paused,
+		}
+		ts.InMaintenance = c.maintenance.InMaintenance(s.Target, now)
+		if !state.LastSample.IsZero() {
+			ts.State = "down"
+			if state.IsOK {
+				ts.State = "up"
+			}
+			ts.Since = &state.Since
+			ts.InStateSeconds = now.Sub(state.Since).Seconds()
+			ts.LastSample = &state.LastSample
+			ts.LastLatencyMs = state.LastLatency.Seconds() * 1000
+		}
+		if state.LastError != nil {
+			ts.LastError = state.LastError.Error()
+		}
+		targets = append(targets, ts)
+	}
+	return targets
+}
+
+// StatusHandler returns a read-only handler reporting the current state of
+// every sensor, as JSON at /status and as a page at /status.html.
+func (c *Canary) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.status())
+	})
+	mux.HandleFunc("/status.html", func(w http.ResponseWriter, r *http.Request) {
+		targets := c.status()
+		page := struct {
+			Targets  []targetStatus
+			Up, Down int
+			Time     time.Time
+		}{Targets: targets, Time: time.Now()}
+		for _, t := range targets {
+			switch t.State {
+			case "up":
+				page.Up++
+			case "down":
+				page.Down++
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statusPage.Execute(w, page)
+	})
+	return mux
+}
+
+var statusPage = template.Must(template.New("status").Funcs(template.FuncMap{
+	"duration": func(seconds float64) time.Duration {
+		return time.Duration(seconds) * time.Second
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>canaryd status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 8px; text-align: left; border-bottom: 1px solid #ddd; }
+.up { color: #080; }
+.down { color: #c00; font-weight: bold; }
+.unknown { color: #888; }
+</style>
+</head>
+<body>
+<h1>canaryd status</h1>
+<p>{{.Up}} up, {{.Down}} down, as of {{.Time.Format "2006-01-02T15:04:05Z07:00"}}</p>
+<table>
+<tr><th>Name</th><th>URL</th><th>Tags</th><th>State</th><th>For</th><th>Last sample</th><th>Latency (ms)</th><th>Last error</th></tr>
+{{range .Targets}}<tr>
+<td>{{.Name}}</td>
+<td><a href="{{.URL}}">{{.URL}}</a></td>
+<td>{{range $i, $t := .Tags}}{{if $i}}, {{end}}{{$t}}{{end}}</td>
+<td class="{{.State}}">{{.State}}{{if .Flapping}} (flapping){{end}}{{if .InMaintenance}} (maintenance){{end}}{{if .Paused}} (paused){{end}}</td>
+<td>{{if .Since}}{{duration .InStateSeconds}}{{end}}</td>
+<td>{{if .LastSample}}{{.LastSample.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</td>
+<td>{{if .LastSample}}{{printf "%.1f" .LastLatencyMs}}{{end}}</td>
+<td>{{.LastError}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,81 @@
+package canary
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canaryio/canary/pkg/manifest"
+	"github.com/canaryio/canary/pkg/sampler"
+)
+
+func TestStatus(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer site.Close()
+
+	var targets []sampler.Target
+	for _, name := range []string{"ok", "broken"} {
+		u, _ := sampler.NewJsonURL(site.URL + "/" + name)
+		target := sampler.Target{URL: *u, Name: name, Interval: 1, Tags: []string{"test"}}
+		target.SetHash()
+		targets = append(targets, target)
+	}
+
+	c := New(nil)
+	c.Manifest = manifest.Manifest{Targets: targets, StartDelays: []float64{0, 0}}
+	c.Run()
+
+	ts := httptest.NewServer(c.StatusHandler())
+	defer ts.Close()
+
+	var status []targetStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(ts.URL + "/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(status) == 2 && status[0].State != "unknown" && status[1].State != "unknown" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for samples, got %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ok, broken := status[0], status[1]
+	if ok.Name != "ok" || ok.State != "up" || ok.LastError != "" || ok.LastSample == nil || ok.Since == nil {
+		t.Errorf("expected ok to be up, got %+v", ok)
+	}
+	if broken.Name != "broken" || broken.State != "down" || !strings.Contains(broken.LastError, "500") {
+		t.Errorf("expected broken to be down with an error, got %+v", broken)
+	}
+	if len(ok.Tags) != 1 || ok.URL != site.URL+"/ok" {
+		t.Errorf("expected the target's URL and tags, got %+v", ok)
+	}
+
+	resp, err := http.Get(ts.URL + "/status.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "1 up, 1 down") || !strings.Contains(string(body), site.URL+"/broken") {
+		t.Errorf("expected the page to summarize both targets, got %s", body)
+	}
+}